@@ -0,0 +1,312 @@
+package db
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// ErrInvalidAggregation is returned for an unknown interval or grouping.
+var ErrInvalidAggregation = errors.New("invalid transaction aggregation")
+
+// Bucket intervals for transaction analytics.
+const (
+    IntervalDay   = "day"
+    IntervalWeek  = "week" // Weeks start on Monday, as in ISO 8601
+    IntervalMonth = "month"
+)
+
+// Groupings for transaction analytics.
+const (
+    GroupByUser    = "user"
+    GroupByCountry = "country"
+    GroupByState   = "state" // Grouped with the country, since state names repeat across countries
+)
+
+// TransactionBucket aggregates the transactions of one group and currency
+// in one interval. Amounts are in minor units; amounts in different
+// currencies are never added together.
+type TransactionBucket struct {
+    Start    time.Time `json:"start"` // Beginning of the interval in the requested time zone
+    UserID   int       `json:"user_id,omitempty"`
+    Country  string    `json:"country,omitempty"`
+    State    string    `json:"state,omitempty"`
+    Currency string    `json:"currency"`
+    Count    int64     `json:"count"`
+    Sum      int64     `json:"sum"`
+    Average  float64   `json:"average"`
+}
+
+// TransactionAnalytics is the result of AggregateTransactions.
+type TransactionAnalytics struct {
+    Interval string              `json:"interval"`
+    GroupBy  string              `json:"group_by,omitempty"`
+    TimeZone string              `json:"time_zone"`
+    From     *time.Time          `json:"from,omitempty"`
+    To       *time.Time          `json:"to,omitempty"`
+    Buckets  []TransactionBucket `json:"buckets"`
+}
+
+// bucketStart returns the beginning of the interval containing t, in loc.
+// Dates are rebuilt from calendar fields so days across a daylight saving
+// change keep their local midnight.
+func bucketStart(t time.Time, interval string, loc *time.Location) time.Time {
+    t = t.In(loc)
+    switch interval {
+    case IntervalWeek:
+        offset := (int(t.Weekday()) + 6) % 7
+        return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc)
+    case IntervalMonth:
+        return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+    }
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// bucketExpression returns SQL yielding the local date, as YYYY-MM-DD, that
+// starts the interval containing a transaction, with its arguments. It
+// returns ok false when the database can't convert to loc: SQLite and SQL
+// Server have no IANA time zones, and MySQL only has them once its time
+// zone tables are loaded.
+func bucketExpression(db *gorm.DB, interval string, loc *time.Location) (string, []interface{}, bool) {
+    utc := loc == time.UTC || loc.String() == "UTC"
+    switch db.Dialector.Name() {
+    case "postgres":
+        if loc.String() == "Local" {
+            return "", nil, false
+        }
+        local := "(transactions.date AT TIME ZONE ?)"
+        return fmt.Sprintf("to_char(date_trunc('%s', %s), 'YYYY-MM-DD')", interval, local), []interface{}{loc.String()}, true
+    case "mysql":
+        local, args := "transactions.date", []interface{}{}
+        if !utc {
+            var supported bool
+            if err := db.Raw("SELECT CONVERT_TZ('2000-01-01 00:00:00', '+00:00', ?) IS NOT NULL", loc.String()).Scan(&supported).Error; err != nil || !supported {
+                return "", nil, false
+            }
+            local, args = "CONVERT_TZ(transactions.date, '+00:00', ?)", []interface{}{loc.String()}
+        }
+        switch interval {
+        case IntervalWeek:
+            return fmt.Sprintf("DATE_FORMAT(%s - INTERVAL WEEKDAY(%s) DAY, '%%Y-%%m-%%d')", local, local), append(args, args...), true
+        case IntervalMonth:
+            return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-01')", local), args, true
+        }
+        return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%d')", local), args, true
+    case "sqlserver":
+        if !utc {
+            return "", nil, false
+        }
+        day := "CAST(SWITCHOFFSET(transactions.date, '+00:00') AS date)"
+        switch interval {
+        case IntervalWeek:
+            // Counts days back to Monday whatever DATEFIRST is set to
+            day = fmt.Sprintf("DATEADD(day, -((DATEPART(weekday, %s) + @@DATEFIRST + 5) %% 7), %s)", day, day)
+        case IntervalMonth:
+            day = fmt.Sprintf("DATEFROMPARTS(YEAR(%s), MONTH(%s), 1)", day, day)
+        }
+        return fmt.Sprintf("CONVERT(char(10), %s, 23)", day), nil, true
+    case "sqlite":
+        if !utc {
+            return "", nil, false
+        }
+        switch interval {
+        case IntervalWeek:
+            return "date(transactions.date, 'weekday 0', '-6 days')", nil, true
+        case IntervalMonth:
+            return "strftime('%Y-%m-01', transactions.date)", nil, true
+        }
+        return "date(transactions.date)", nil, true
+    }
+    return "", nil, false
+}
+
+// AggregateTransactions counts, sums and averages the transactions matching
+// filter per interval, optionally per user, country or state. Interval
+// boundaries are local midnights in loc. The database groups the rows when
+// it can compute the boundaries itself; otherwise rows are bucketed here as
+// they stream in, which callers should bound with a date range.
+func AggregateTransactions(ctx context.Context, db *gorm.DB, filter TransactionFilter, interval, groupBy string, loc *time.Location) (*TransactionAnalytics, error) {
+    if interval == "" {
+        interval = IntervalDay
+    }
+    if interval != IntervalDay && interval != IntervalWeek && interval != IntervalMonth {
+        return nil, fmt.Errorf("%w: interval must be day, week or month", ErrInvalidAggregation)
+    }
+    if groupBy != "" && groupBy != GroupByUser && groupBy != GroupByCountry && groupBy != GroupByState {
+        return nil, fmt.Errorf("%w: group_by must be user, country or state", ErrInvalidAggregation)
+    }
+
+    db = db.WithContext(ctx)
+    var buckets []TransactionBucket
+    var err error
+    if expr, args, ok := bucketExpression(db, interval, loc); ok {
+        buckets, err = groupTransactions(db, filter, groupBy, loc, expr, args)
+    } else {
+        buckets, err = bucketTransactions(db, filter, interval, groupBy, loc)
+    }
+    if err != nil {
+        log.Println("Error aggregating transactions:", err)
+        return nil, err
+    }
+
+    result := &TransactionAnalytics{
+        Interval: interval,
+        GroupBy:  groupBy,
+        TimeZone: loc.String(),
+        From:     filter.From,
+        To:       filter.To,
+        Buckets:  buckets,
+    }
+    for i := range result.Buckets {
+        bucket := &result.Buckets[i]
+        bucket.Average = float64(bucket.Sum) / float64(bucket.Count)
+    }
+    sort.Slice(result.Buckets, func(i, j int) bool {
+        a, b := result.Buckets[i], result.Buckets[j]
+        switch {
+        case !a.Start.Equal(b.Start):
+            return a.Start.Before(b.Start)
+        case a.UserID != b.UserID:
+            return a.UserID < b.UserID
+        case a.Country != b.Country:
+            return a.Country < b.Country
+        case a.State != b.State:
+            return a.State < b.State
+        }
+        return a.Currency < b.Currency
+    })
+    return result, nil
+}
+
+// groupTransactions aggregates in the database, grouping on bucketExpr.
+func groupTransactions(db *gorm.DB, filter TransactionFilter, groupBy string, loc *time.Location, bucketExpr string, args []interface{}) ([]TransactionBucket, error) {
+    columns := []string{"bucket", "currency"}
+    inner := db.Model(&Transaction{}).Scopes(filter.Scope)
+    selects := bucketExpr + " AS bucket, transactions.currency, transactions.amount, transactions.user_id"
+    switch groupBy {
+    case GroupByUser:
+        columns = append(columns, "user_id")
+    case GroupByCountry, GroupByState:
+        inner = inner.Joins("JOIN users ON users.id = transactions.user_id")
+        selects += ", users.country, users.state"
+        columns = append(columns, "country")
+        if groupBy == GroupByState {
+            columns = append(columns, "state")
+        }
+    }
+    inner = inner.Select(selects, args...)
+
+    group := strings.Join(columns, ", ")
+    var rows []struct {
+        Bucket   string
+        Currency string
+        UserID   int
+        Country  *string
+        State    *string
+        Count    int64 `gorm:"column:transaction_count"`
+        Sum      int64 `gorm:"column:amount_sum"`
+    }
+    err := db.Table("(?) AS t", inner).
+        Select(group + ", COUNT(*) AS transaction_count, SUM(amount) AS amount_sum").
+        Group(group).
+        Scan(&rows).Error
+    if err != nil {
+        return nil, err
+    }
+
+    buckets := make([]TransactionBucket, 0, len(rows))
+    for _, row := range rows {
+        start, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(row.Bucket), loc)
+        if err != nil {
+            return nil, fmt.Errorf("unexpected bucket %q: %v", row.Bucket, err)
+        }
+        bucket := TransactionBucket{
+            Start:    start,
+            UserID:   row.UserID,
+            Currency: row.Currency,
+            Count:    row.Count,
+            Sum:      row.Sum,
+        }
+        if row.Country != nil {
+            bucket.Country = *row.Country
+        }
+        if row.State != nil {
+            bucket.State = *row.State
+        }
+        buckets = append(buckets, bucket)
+    }
+    return buckets, nil
+}
+
+// bucketTransactions aggregates by streaming the matching rows and
+// bucketing them here, for databases that can't convert to loc. Only the
+// buckets are held in memory.
+func bucketTransactions(db *gorm.DB, filter TransactionFilter, interval, groupBy string, loc *time.Location) ([]TransactionBucket, error) {
+    query := db.Model(&Transaction{}).Scopes(filter.Scope)
+    switch groupBy {
+    case GroupByCountry, GroupByState:
+        query = query.Joins("JOIN users ON users.id = transactions.user_id").
+            Select("transactions.date, transactions.amount, transactions.currency, transactions.user_id, users.country, users.state")
+    default:
+        query = query.Select("transactions.date, transactions.amount, transactions.currency, transactions.user_id, '' AS country, '' AS state")
+    }
+    rows, err := query.Rows()
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    buckets := make(map[TransactionBucket]*TransactionBucket)
+    for rows.Next() {
+        var row struct {
+            Date     time.Time
+            Amount   int64
+            Currency string
+            UserID   int
+            Country  *string
+            State    *string
+        }
+        if err := db.ScanRows(rows, &row); err != nil {
+            return nil, err
+        }
+
+        key := TransactionBucket{Start: bucketStart(row.Date, interval, loc), Currency: row.Currency}
+        switch groupBy {
+        case GroupByUser:
+            key.UserID = row.UserID
+        case GroupByState:
+            if row.State != nil {
+                key.State = *row.State
+            }
+            fallthrough
+        case GroupByCountry:
+            if row.Country != nil {
+                key.Country = *row.Country
+            }
+        }
+
+        bucket, ok := buckets[key]
+        if !ok {
+            b := key
+            bucket = &b
+            buckets[key] = bucket
+        }
+        bucket.Count++
+        bucket.Sum += row.Amount
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    result := make([]TransactionBucket, 0, len(buckets))
+    for _, bucket := range buckets {
+        result = append(result, *bucket)
+    }
+    return result, nil
+}
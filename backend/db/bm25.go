@@ -0,0 +1,121 @@
+package db
+
+import (
+    "math"
+    "sort"
+    "strings"
+    "unicode"
+
+    "gorm.io/gorm"
+)
+
+// BM25 parameters: k1 controls term frequency saturation and b how much
+// document length is normalized. These are the usual defaults.
+const (
+    bm25K1 = 1.2
+    bm25B  = 0.75
+)
+
+// stopWords are too common in questions to say anything about similarity.
+var stopWords = map[string]bool{
+    "a": true, "an": true, "and": true, "are": true, "as": true, "by": true,
+    "do": true, "does": true, "for": true, "from": true, "how": true, "i": true,
+    "in": true, "is": true, "it": true, "me": true, "of": true, "on": true,
+    "or": true, "show": true, "the": true, "to": true, "was": true, "were": true,
+    "what": true, "which": true, "who": true, "with": true, "list": true, "give": true,
+}
+
+// tokenize lower-cases text and splits it into words, dropping stop words.
+func tokenize(text string) []string {
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+    tokens := words[:0]
+    for _, word := range words {
+        if !stopWords[word] {
+            tokens = append(tokens, word)
+        }
+    }
+    return tokens
+}
+
+// RankExamples returns up to k examples whose questions are most similar to
+// question by BM25, best first. Examples sharing no terms are left out.
+func RankExamples(examples []SQLExample, question string, k int) []SQLExample {
+    query := tokenize(question)
+    if len(examples) == 0 || len(query) == 0 || k <= 0 {
+        return nil
+    }
+
+    docs := make([][]string, len(examples))
+    docFreq := make(map[string]int)
+    totalLen := 0
+    for i, example := range examples {
+        docs[i] = tokenize(example.Question)
+        totalLen += len(docs[i])
+        seen := make(map[string]bool)
+        for _, term := range docs[i] {
+            if !seen[term] {
+                seen[term] = true
+                docFreq[term]++
+            }
+        }
+    }
+    avgLen := float64(totalLen) / float64(len(docs))
+    n := float64(len(docs))
+
+    type scored struct {
+        index int
+        score float64
+    }
+    var results []scored
+    for i, doc := range docs {
+        termFreq := make(map[string]int)
+        for _, term := range doc {
+            termFreq[term]++
+        }
+
+        score := 0.0
+        for _, term := range query {
+            tf := float64(termFreq[term])
+            if tf == 0 {
+                continue
+            }
+            df := float64(docFreq[term])
+            idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+            norm := 1 - bm25B + bm25B*float64(len(doc))/avgLen
+            score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+        }
+        if score > 0 {
+            results = append(results, scored{index: i, score: score})
+        }
+    }
+
+    sort.SliceStable(results, func(i, j int) bool {
+        return results[i].score > results[j].score
+    })
+    if len(results) > k {
+        results = results[:k]
+    }
+
+    ranked := make([]SQLExample, len(results))
+    for i, r := range results {
+        ranked[i] = examples[r.index]
+    }
+    return ranked
+}
+
+// SimilarExamples loads a user's examples for a database and returns the k
+// most similar to question as prompt examples.
+func SimilarExamples(db *gorm.DB, userID int, connectionKey, question string, k int) ([]PromptExample, error) {
+    examples, err := GetSQLExamples(db, userID, connectionKey)
+    if err != nil {
+        return nil, err
+    }
+
+    var prompt []PromptExample
+    for _, example := range RankExamples(examples, question, k) {
+        prompt = append(prompt, PromptExample{Question: example.Question, SQL: example.SQLQuery})
+    }
+    return prompt, nil
+}
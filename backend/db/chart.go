@@ -0,0 +1,190 @@
+package db
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Column roles used to pick a chart.
+const (
+    RoleTemporal    = "temporal"
+    RoleCategorical = "categorical"
+    RoleNumeric     = "numeric"
+)
+
+// Chart types a result can be recommended as.
+const (
+    ChartBar     = "bar"
+    ChartLine    = "line"
+    ChartScatter = "scatter"
+    ChartPie     = "pie"
+    ChartTable   = "table"
+)
+
+// Limits on when a chart is still readable.
+const (
+    maxPieSlices     = 6  // More categories than this make a bar chart
+    maxBarCategories = 50 // More categories than this are left as a table
+)
+
+// ColumnProfile is a result column with the role it can play in a chart.
+type ColumnProfile struct {
+    ColumnSummary
+    Role     string `json:"role,omitempty"` // Empty for columns that are entirely NULL
+    Distinct int    `json:"distinct"`       // Distinct non-NULL values among the sampled rows
+}
+
+// ChartSpec is a suggested visualization of a result. VegaLite is a
+// Vega-Lite fragment with mark and encoding, for the client to add data to;
+// it is omitted for tables.
+type ChartSpec struct {
+    Type     string                 `json:"type"`
+    X        string                 `json:"x,omitempty"`
+    Y        string                 `json:"y,omitempty"`
+    Color    string                 `json:"color,omitempty"`
+    Columns  []ColumnProfile        `json:"columns"`
+    VegaLite map[string]interface{} `json:"vega_lite,omitempty"`
+}
+
+// vegaTypes maps column roles to Vega-Lite field types.
+var vegaTypes = map[string]string{
+    RoleTemporal:    "temporal",
+    RoleCategorical: "nominal",
+    RoleNumeric:     "quantitative",
+}
+
+// ProfileColumns classifies result columns as temporal, categorical or
+// numeric from their inferred types.
+func ProfileColumns(columns []string, rows []map[string]interface{}) []ColumnProfile {
+    if len(rows) > columnSampleRows {
+        rows = rows[:columnSampleRows]
+    }
+
+    profiles := make([]ColumnProfile, len(columns))
+    for i, summary := range SummarizeColumns(columns, rows) {
+        profile := ColumnProfile{ColumnSummary: summary}
+        switch summary.Type {
+        case ColumnTime:
+            profile.Role = RoleTemporal
+        case ColumnNumber:
+            profile.Role = RoleNumeric
+        case ColumnText, ColumnBoolean:
+            profile.Role = RoleCategorical
+        }
+
+        seen := make(map[string]bool)
+        for _, row := range rows {
+            if value := row[summary.Name]; value != nil {
+                seen[fmt.Sprint(value)] = true
+            }
+        }
+        profile.Distinct = len(seen)
+        profiles[i] = profile
+    }
+    return profiles
+}
+
+// RecommendChart suggests how to visualize a result: a line over time, bars
+// or a pie per category, a scatter of two measures, or else a table.
+func RecommendChart(columns []string, rows []map[string]interface{}) *ChartSpec {
+    profiles := ProfileColumns(columns, rows)
+    spec := &ChartSpec{Type: ChartTable, Columns: profiles}
+    if len(rows) == 0 {
+        return spec
+    }
+
+    byRole := make(map[string][]ColumnProfile)
+    for _, p := range profiles {
+        if p.Role != "" {
+            byRole[p.Role] = append(byRole[p.Role], p)
+        }
+    }
+    temporal, categorical, numeric := byRole[RoleTemporal], byRole[RoleCategorical], byRole[RoleNumeric]
+
+    var x, y, color *ColumnProfile
+    switch {
+    case len(temporal) > 0 && len(numeric) > 0:
+        spec.Type = ChartLine
+        x, y = &temporal[0], &numeric[0]
+        if len(categorical) > 0 && categorical[0].Distinct <= maxBarCategories {
+            color = &categorical[0]
+        }
+    case len(categorical) > 0 && len(numeric) > 0:
+        category := &categorical[0]
+        switch {
+        case category.Distinct > maxBarCategories:
+            return spec
+        case len(numeric) == 1 && category.Distinct <= maxPieSlices && category.Distinct == len(rows) && nonNegative(rows, numeric[0].Name):
+            spec.Type = ChartPie
+        default:
+            spec.Type = ChartBar
+        }
+        x, y = category, &numeric[0]
+        if len(categorical) > 1 && categorical[1].Distinct <= maxPieSlices {
+            color = &categorical[1]
+        }
+    case len(numeric) >= 2:
+        spec.Type = ChartScatter
+        x, y = &numeric[0], &numeric[1]
+        if len(categorical) > 0 && categorical[0].Distinct <= maxBarCategories {
+            color = &categorical[0]
+        }
+    default:
+        return spec
+    }
+
+    spec.X, spec.Y = x.Name, y.Name
+    encoding := map[string]interface{}{}
+    if spec.Type == ChartPie {
+        // Pies encode the measure as the angle and the category as the colour
+        encoding["theta"] = vegaField(y)
+        encoding["color"] = vegaField(x)
+    } else {
+        encoding["x"] = vegaField(x)
+        encoding["y"] = vegaField(y)
+        if color != nil {
+            spec.Color = color.Name
+            encoding["color"] = vegaField(color)
+        }
+    }
+
+    mark := spec.Type
+    switch spec.Type {
+    case ChartPie:
+        mark = "arc"
+    case ChartScatter:
+        mark = "point"
+    }
+    spec.VegaLite = map[string]interface{}{
+        "$schema":  "https://vega.github.io/schema/vega-lite/v5.json",
+        "mark":     map[string]interface{}{"type": mark, "tooltip": true},
+        "encoding": encoding,
+    }
+    return spec
+}
+
+// vegaField is the Vega-Lite encoding of a column.
+func vegaField(p *ColumnProfile) map[string]interface{} {
+    return map[string]interface{}{"field": p.Name, "type": vegaTypes[p.Role]}
+}
+
+// nonNegative reports whether a numeric column has no negative values,
+// which a pie chart can't show.
+func nonNegative(rows []map[string]interface{}, column string) bool {
+    for _, row := range rows {
+        var f float64
+        switch v := row[column].(type) {
+        case int64:
+            f = float64(v)
+        case float64:
+            f = v
+        case string:
+            f, _ = strconv.ParseFloat(strings.TrimSpace(v), 64)
+        }
+        if f < 0 {
+            return false
+        }
+    }
+    return true
+}
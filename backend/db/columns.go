@@ -0,0 +1,102 @@
+package db
+
+import (
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Column types inferred from result values.
+const (
+    ColumnNumber  = "number"
+    ColumnText    = "text"
+    ColumnBoolean = "boolean"
+    ColumnTime    = "time"
+    ColumnNull    = "null" // Every sampled value was NULL
+)
+
+// columnSampleRows is how many rows are inspected to infer column types.
+const columnSampleRows = 200
+
+// timeLayouts are the textual date formats recognized in results, as
+// returned by drivers that report dates as strings (notably SQLite).
+var timeLayouts = []string{
+    time.RFC3339Nano,
+    "2006-01-02 15:04:05.999999999-07:00",
+    "2006-01-02 15:04:05.999999999",
+    "2006-01-02T15:04:05",
+    "2006-01-02",
+    "2006-01",
+}
+
+// ColumnSummary describes a result column.
+type ColumnSummary struct {
+    Name string `json:"name"`
+    Type string `json:"type"`
+}
+
+// SummarizeColumns infers each column's type from the first rows of a
+// result. Columns holding values of different types are text.
+func SummarizeColumns(columns []string, rows []map[string]interface{}) []ColumnSummary {
+    if len(rows) > columnSampleRows {
+        rows = rows[:columnSampleRows]
+    }
+
+    summaries := make([]ColumnSummary, len(columns))
+    for i, name := range columns {
+        kind := ColumnNull
+        for _, row := range rows {
+            valueKind := ValueType(row[name])
+            if valueKind == ColumnNull {
+                continue
+            }
+            if kind == ColumnNull {
+                kind = valueKind
+            } else if kind != valueKind {
+                kind = ColumnText
+                break
+            }
+        }
+        summaries[i] = ColumnSummary{Name: name, Type: kind}
+    }
+    return summaries
+}
+
+// ValueType classifies a single value from ScanRowMaps. Strings holding
+// numbers or dates count as such, since drivers often return decimals and
+// dates as text.
+func ValueType(value interface{}) string {
+    switch v := value.(type) {
+    case nil:
+        return ColumnNull
+    case int64, int32, int, float64, float32, uint64:
+        return ColumnNumber
+    case bool:
+        return ColumnBoolean
+    case time.Time:
+        return ColumnTime
+    case string:
+        if _, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+            return ColumnNumber
+        }
+        if _, ok := ParseTimeValue(v); ok {
+            return ColumnTime
+        }
+    }
+    return ColumnText
+}
+
+// ParseTimeValue reads a date or timestamp from a result value.
+func ParseTimeValue(value interface{}) (time.Time, bool) {
+    switch v := value.(type) {
+    case time.Time:
+        return v, true
+    case string:
+        for _, layout := range timeLayouts {
+            if t, err := time.Parse(layout, v); err == nil {
+                return t, true
+            }
+        }
+    }
+    return time.Time{}, false
+}
@@ -1,14 +1,15 @@
 package db
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
+	"strings"
 	"time"
 	"regexp"
 	"github.com/jackc/pgx/v4"
@@ -24,9 +25,13 @@ func maskSensitiveInfo(dsn string) string {
     return regexp.MustCompile(`:[^:@]+@`).ReplaceAllString(dsn, ":***@")
 }
 
-// SQLQueryResponse struct to handle the response from Python text-to-SQL service
-type SQLQueryResponse struct {
-	SQLQuery string `json:"sql_query"`
+// ConnectionKey identifies a database across reconnects and restarts, unlike
+// the per-process connection ID. Passwords are left out so rotating one
+// keeps the key.
+func ConnectionKey(driver, dsn string) string {
+	dsn = regexp.MustCompile(`(?i)password=[^ ;&]*`).ReplaceAllString(maskSensitiveInfo(dsn), "")
+	sum := sha256.Sum256([]byte(driver + "\x00" + dsn))
+	return hex.EncodeToString(sum[:])[:16]
 }
 
 // ConnectDB connects to CockroachDB and prints the current time.
@@ -65,8 +70,9 @@ func ConnectDB() (*gorm.DB, error) {
 	return db, nil
 }
 
-// ConnectUserDatabase dynamically connects to the specified database using GORM.
-func ConnectUserDatabase(dsn string, driver string) (*gorm.DB, error) {
+// ConnectUserDatabase dynamically connects to the specified database using GORM
+// and returns the server's version, which is empty if it couldn't be read.
+func ConnectUserDatabase(dsn string, driver string) (*gorm.DB, string, error) {
     log.Printf("=== Attempting to connect to %s database ===", driver)
     
     var db *gorm.DB
@@ -93,85 +99,318 @@ func ConnectUserDatabase(dsn string, driver string) (*gorm.DB, error) {
         db, err = gorm.Open(sqlserver.Open(dsn), &gorm.Config{})
     default:
         log.Printf("❌ Unsupported database driver: %s", driver)
-        return nil, fmt.Errorf("unsupported database driver: %s", driver)
+        return nil, "", fmt.Errorf("unsupported database driver: %s", driver)
     }
     
     if err != nil {
         log.Printf("❌ Failed to connect to database: %v", err)
-        return nil, fmt.Errorf("failed to connect to %s: %v", driver, err)
+        return nil, "", fmt.Errorf("failed to connect to %s: %v", driver, err)
     }
     
     // Test the connection
     sqlDB, err := db.DB()
     if err != nil {
         log.Printf("❌ Failed to get underlying *sql.DB: %v", err)
-        return nil, fmt.Errorf("failed to get database instance: %v", err)
+        return nil, "", fmt.Errorf("failed to get database instance: %v", err)
     }
     
     err = sqlDB.Ping()
     if err != nil {
         log.Printf("❌ Failed to ping database: %v", err)
-        return nil, fmt.Errorf("failed to ping database: %v", err)
+        return nil, "", fmt.Errorf("failed to ping database: %v", err)
     }
     
     log.Println("✅ Successfully connected to database")
+
+    version, err := ServerVersion(context.Background(), db)
+    if err != nil {
+        log.Printf("Could not read server version: %v", err)
+    } else {
+        log.Printf("Server version: %s", version)
+    }
     
     // Log some connection pool settings
     log.Printf("Connection pool settings:")
     log.Printf("  Max idle connections: %d", sqlDB.Stats().Idle)
     log.Printf("  Max open connections: %d", sqlDB.Stats().OpenConnections)
     
-    return db, nil
+    return db, version, nil
+}
+
+// ServerVersion asks the database for its product version.
+func ServerVersion(ctx context.Context, db *gorm.DB) (string, error) {
+    var query string
+    switch db.Dialector.Name() {
+    case "postgres":
+        query = "SELECT version()"
+    case "mysql":
+        query = "SELECT VERSION()"
+    case "sqlite":
+        query = "SELECT sqlite_version()"
+    case "sqlserver":
+        query = "SELECT CAST(SERVERPROPERTY('ProductVersion') AS NVARCHAR(128))"
+    default:
+        return "", fmt.Errorf("unsupported database driver: %s", db.Dialector.Name())
+    }
+
+    var version string
+    if err := db.WithContext(ctx).Raw(query).Scan(&version).Error; err != nil {
+        return "", err
+    }
+    // Postgres appends build details, as in "PostgreSQL 16.2 on x86_64-pc-linux-gnu, ..."
+    if before, _, ok := strings.Cut(version, " on "); ok {
+        version = before
+    }
+    return strings.TrimSpace(version), nil
+}
+
+// QueryRows is the result of ExecuteSQLQuery. The query runs on a dedicated
+// connection which is returned to the pool by Close.
+type QueryRows struct {
+	*sql.Rows
+	session *querySession
+}
+
+// Close closes the rows, stops watching for cancellation and releases the connection.
+func (r *QueryRows) Close() error {
+	err := r.Rows.Close()
+	r.session.release()
+	return err
+}
+
+// querySession is the connection a query is pinned to, along with the
+// read-only transaction it runs in, if any.
+type querySession struct {
+	conn     *sql.Conn
+	tx       *sql.Tx
+	dialect  string
+	stop     func() bool
+	canceled chan struct{} // Closed once a started cancellation has finished
+}
+
+// watchCancel runs cancelBackend for backend id once ctx is done.
+func (s *querySession) watchCancel(ctx context.Context, sqlDB *sql.DB, id int64) {
+	s.canceled = make(chan struct{})
+	s.stop = context.AfterFunc(ctx, func() {
+		defer close(s.canceled)
+		cancelBackend(sqlDB, s.dialect, id)
+	})
+}
+
+// release stops watching for cancellation, ends the read-only transaction
+// and returns the connection to the pool. If the cancellation already
+// fired, it waits for it to finish and discards the connection instead: a
+// KILL QUERY or pg_cancel_backend arriving late would otherwise cancel
+// whatever query the pool hands that session to next. So is a connection
+// that couldn't be put back into read-write mode.
+func (s *querySession) release() {
+	discard := false
+	if !s.stop() {
+		<-s.canceled
+		discard = true
+	}
+	if s.tx != nil && !endReadOnly(s.conn, s.tx, s.dialect) {
+		discard = true
+	}
+	if discard {
+		s.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	s.conn.Close()
+}
+
+// beginReadOnly starts a transaction on conn in which statements can't
+// write. SQL Server has no read-only transactions, so there the
+// transaction only guarantees that writes are rolled back by endReadOnly.
+func beginReadOnly(ctx context.Context, conn *sql.Conn, dialect string) (*sql.Tx, error) {
+	switch dialect {
+	case "sqlite":
+		// The driver ignores TxOptions.ReadOnly
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return nil, err
+		}
+		return conn.BeginTx(ctx, nil)
+	case "sqlserver":
+		return conn.BeginTx(ctx, nil)
+	}
+	return conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+}
+
+// endReadOnly rolls back tx and restores conn's read-write mode, reporting
+// whether conn is fit to be reused.
+func endReadOnly(conn *sql.Conn, tx *sql.Tx, dialect string) bool {
+	// A transaction whose context was cancelled has already been rolled back
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		log.Println("Error rolling back read-only transaction:", err)
+		return false
+	}
+	if dialect == "sqlite" {
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = OFF"); err != nil {
+			log.Println("Error leaving SQLite query-only mode:", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Execute the SQL query against the connected database. The query is bound to
+// ctx: when it is cancelled or its deadline passes, the driver aborts the call
+// and the server-side statement is cancelled as well (see cancelBackend).
+// args, if any, must use the driver's native placeholders.
+func ExecuteSQLQuery(ctx context.Context, db *gorm.DB, sqlQuery string, args ...interface{}) (*QueryRows, error) {
+	return executeQuery(ctx, db, false, sqlQuery, args)
 }
 
+// ExecuteReadOnlyQuery is ExecuteSQLQuery for SQL that isn't trusted to
+// only read, such as generated SQL. The query runs in a read-only
+// transaction (query_only mode on SQLite) which is rolled back on Close.
+func ExecuteReadOnlyQuery(ctx context.Context, db *gorm.DB, sqlQuery string, args ...interface{}) (*QueryRows, error) {
+	return executeQuery(ctx, db, true, sqlQuery, args)
+}
 
-// Call the Python text-to-SQL service to convert text query into SQL.
-func convertTextToSQL(textQuery string) (string, error) {
-	// Prepare the request payload
-	data := map[string]string{"text_query": textQuery}
-	jsonData, err := json.Marshal(data)
+func executeQuery(ctx context.Context, db *gorm.DB, readOnly bool, sqlQuery string, args []interface{}) (*QueryRows, error) {
+	sqlDB, err := db.DB()
 	if err != nil {
-		return "", err
+		log.Println("Error getting database instance:", err)
+		return nil, err
 	}
-	// Call the Python service
-	resp, err := http.Post("http://localhost:5000/api/query", "application/json", bytes.NewBuffer(jsonData))
+
+	// Pin a connection so we know which server session to cancel
+	conn, err := sqlDB.Conn(ctx)
 	if err != nil {
-		return "", err
+		log.Println("Error acquiring connection:", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Read and parse the response
-	body, err := ioutil.ReadAll(resp.Body)
+	session := &querySession{conn: conn, dialect: db.Dialector.Name()}
+	backendID, err := backendID(ctx, conn, session.dialect)
 	if err != nil {
-		return "", err
+		// Not fatal: the driver still aborts the client side on cancellation
+		log.Printf("Could not determine %s backend ID, server-side cancellation disabled: %v", session.dialect, err)
+	}
+	session.watchCancel(ctx, sqlDB, backendID)
+
+	var pool gorm.ConnPool = conn
+	if readOnly {
+		session.tx, err = beginReadOnly(ctx, conn, session.dialect)
+		if err != nil {
+			log.Println("Error starting read-only transaction:", err)
+			// The connection may be left half-way into read-only mode
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			session.release()
+			return nil, err
+		}
+		pool = session.tx
 	}
 
-	var response SQLQueryResponse
-	err = json.Unmarshal(body, &response)
+	var rows *sql.Rows
+	if len(args) > 0 {
+		// Arguments are already bound to the driver's native placeholders
+		// (see BindParameters); GORM would rewrite any "?" or "@" in the text
+		rows, err = pool.QueryContext(ctx, sqlQuery, args...)
+	} else {
+		// Use GORM's Raw method to execute the query and get the result as *sql.Rows
+		tx := db.WithContext(ctx)
+		tx.Statement.ConnPool = pool
+		rows, err = tx.Raw(sqlQuery).Rows()
+	}
 	if err != nil {
-		return "", err
+		log.Println("Error executing SQL query:", err)
+		session.release()
+		return nil, err
 	}
+	return &QueryRows{Rows: rows, session: session}, nil
+}
 
-	return response.SQLQuery, nil
+// backendID returns the server-side session ID of conn for dialects where
+// the server must be told to cancel a running statement, or 0 otherwise.
+func backendID(ctx context.Context, conn *sql.Conn, dialect string) (int64, error) {
+	var query string
+	switch dialect {
+	case "postgres":
+		query = "SELECT pg_backend_pid()"
+	case "mysql":
+		query = "SELECT CONNECTION_ID()"
+	default:
+		// sqlserver sends an attention packet and sqlite interrupts the
+		// statement when the context is done, so nothing else is needed
+		return 0, nil
+	}
+
+	var id int64
+	if err := conn.QueryRowContext(ctx, query).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-// Execute the SQL query against the connected database.
-func ExecuteSQLQuery(db *gorm.DB, sqlQuery string) (*sql.Rows, error) {
-	// Use GORM's Raw method to execute the query and get the result as *sql.Rows
-	rows, err := db.Raw(sqlQuery).Rows()
+// cancelBackend asks the server to stop the statement running on backend id.
+// It runs on a separate pooled connection since the query's own connection is busy.
+func cancelBackend(sqlDB *sql.DB, dialect string, id int64) {
+	if id == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	switch dialect {
+	case "postgres":
+		_, err = sqlDB.ExecContext(ctx, "SELECT pg_cancel_backend($1)", id)
+	case "mysql":
+		// KILL does not accept placeholders; id is an integer we read ourselves
+		_, err = sqlDB.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", id))
+	}
+	if err != nil {
+		log.Printf("Error cancelling %s backend %d: %v", dialect, id, err)
+		return
+	}
+	log.Printf("Cancelled running query on %s backend %d", dialect, id)
+}
+
+// ScanRowMaps reads the remaining rows into maps keyed by column name.
+func ScanRowMaps(rows *sql.Rows) ([]map[string]interface{}, error) {
+	result := []map[string]interface{}{}
+	err := EachRowMap(rows, func(row map[string]interface{}) error {
+		result = append(result, row)
+		return nil
+	})
 	if err != nil {
-		log.Println("Error executing SQL query:", err)
 		return nil, err
 	}
+	return result, nil
+}
 
-	// Print the columns as an example
+// EachRowMap calls fn with each remaining row as a map keyed by column name,
+// stopping at the first error. Byte slices are converted to strings so they
+// encode as text in JSON.
+func EachRowMap(rows *sql.Rows, fn func(row map[string]interface{}) error) error {
 	columns, err := rows.Columns()
 	if err != nil {
-		log.Println("Error fetching columns:", err)
-		rows.Close() // Make sure to close the rows in case of error
-		return nil, err
+		return err
 	}
-	fmt.Println("Query Result Columns:", columns)
 
-	return rows, nil
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
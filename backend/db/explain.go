@@ -0,0 +1,379 @@
+package db
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+// PlanNode is one operator of a query plan, normalized across dialects.
+// Estimates are zero when the database doesn't report them.
+type PlanNode struct {
+    Operation     string      `json:"operation"`
+    Relation      string      `json:"relation,omitempty"`
+    EstimatedRows float64     `json:"estimated_rows,omitempty"`
+    EstimatedCost float64     `json:"estimated_cost,omitempty"`
+    Detail        string      `json:"detail,omitempty"`
+    Children      []*PlanNode `json:"children,omitempty"`
+}
+
+// QueryPlan is the result of ExplainQuery.
+type QueryPlan struct {
+    Dialect       string    `json:"dialect"`
+    TotalCost     float64   `json:"total_cost"`
+    EstimatedRows float64   `json:"estimated_rows"`
+    CostKnown     bool      `json:"cost_known"` // False for SQLite, which reports no costs
+    Root          *PlanNode `json:"plan"`
+    Raw           string    `json:"raw"` // The plan as the database returned it
+}
+
+// ExplainQuery asks the database for sqlQuery's plan without running it,
+// using the dialect's EXPLAIN variant, and normalizes the result.
+func ExplainQuery(ctx context.Context, db *gorm.DB, sqlQuery string, args ...interface{}) (*QueryPlan, error) {
+    dialect := db.Dialector.Name()
+    sqlQuery = strings.TrimRight(strings.TrimSpace(sqlQuery), "; \t\r\n")
+
+    var plan *QueryPlan
+    err := db.WithContext(ctx).Connection(func(tx *gorm.DB) error {
+        conn := tx.Statement.ConnPool
+        var err error
+        switch dialect {
+        case "postgres":
+            plan, err = explainPostgres(ctx, conn, sqlQuery, args)
+        case "mysql":
+            plan, err = explainMySQL(ctx, conn, sqlQuery, args)
+        case "sqlite":
+            plan, err = explainSQLite(ctx, conn, sqlQuery, args)
+        case "sqlserver":
+            plan, err = explainSQLServer(ctx, conn, sqlQuery, args)
+        default:
+            err = fmt.Errorf("EXPLAIN is not supported for %s", dialect)
+        }
+        return err
+    })
+    if err != nil {
+        log.Println("Error explaining SQL query:", err)
+        return nil, err
+    }
+    plan.Dialect = dialect
+    return plan, nil
+}
+
+// queryText runs a query expected to return a single text value.
+func queryText(ctx context.Context, conn gorm.ConnPool, query string, args []interface{}) (string, error) {
+    var text string
+    if err := conn.QueryRowContext(ctx, query, args...).Scan(&text); err != nil {
+        return "", err
+    }
+    return text, nil
+}
+
+func explainPostgres(ctx context.Context, conn gorm.ConnPool, sqlQuery string, args []interface{}) (*QueryPlan, error) {
+    raw, err := queryText(ctx, conn, "EXPLAIN (FORMAT JSON) "+sqlQuery, args)
+    if err != nil {
+        return nil, err
+    }
+
+    var doc []struct {
+        Plan map[string]interface{} `json:"Plan"`
+    }
+    if err := json.Unmarshal([]byte(raw), &doc); err != nil || len(doc) == 0 {
+        return nil, fmt.Errorf("unexpected EXPLAIN output: %v", err)
+    }
+
+    var convert func(node map[string]interface{}) *PlanNode
+    convert = func(node map[string]interface{}) *PlanNode {
+        n := &PlanNode{
+            Operation:     stringField(node, "Node Type"),
+            Relation:      stringField(node, "Relation Name"),
+            EstimatedRows: numberField(node, "Plan Rows"),
+            EstimatedCost: numberField(node, "Total Cost"),
+        }
+        for _, key := range []string{"Filter", "Index Cond", "Hash Cond", "Join Filter"} {
+            if cond := stringField(node, key); cond != "" {
+                n.Detail = key + ": " + cond
+                break
+            }
+        }
+        if children, ok := node["Plans"].([]interface{}); ok {
+            for _, child := range children {
+                if m, ok := child.(map[string]interface{}); ok {
+                    n.Children = append(n.Children, convert(m))
+                }
+            }
+        }
+        return n
+    }
+
+    root := convert(doc[0].Plan)
+    return &QueryPlan{
+        TotalCost:     root.EstimatedCost,
+        EstimatedRows: root.EstimatedRows,
+        CostKnown:     true,
+        Root:          root,
+        Raw:           raw,
+    }, nil
+}
+
+func explainMySQL(ctx context.Context, conn gorm.ConnPool, sqlQuery string, args []interface{}) (*QueryPlan, error) {
+    raw, err := queryText(ctx, conn, "EXPLAIN FORMAT=JSON "+sqlQuery, args)
+    if err != nil {
+        return nil, err
+    }
+
+    var doc map[string]interface{}
+    if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+        return nil, fmt.Errorf("unexpected EXPLAIN output: %v", err)
+    }
+    block, _ := doc["query_block"].(map[string]interface{})
+    if block == nil {
+        return nil, fmt.Errorf("unexpected EXPLAIN output: no query_block")
+    }
+
+    root := mysqlPlanNode("query_block", block)
+    plan := &QueryPlan{
+        TotalCost: root.EstimatedCost,
+        CostKnown: true,
+        Root:      root,
+        Raw:       raw,
+    }
+    // MySQL doesn't estimate the final row count; the last table joined is the closest figure
+    walkPlan(root, func(n *PlanNode) {
+        if n.Relation != "" {
+            plan.EstimatedRows = n.EstimatedRows
+        }
+    })
+    root.EstimatedRows = plan.EstimatedRows
+    return plan, nil
+}
+
+// mysqlPlanNode converts one object of MySQL's JSON plan. Operations nest
+// as objects (ordering_operation, grouping_operation, ...) and joins as
+// nested_loop arrays of table objects.
+func mysqlPlanNode(operation string, obj map[string]interface{}) *PlanNode {
+    n := &PlanNode{Operation: operation}
+    if cost, ok := obj["cost_info"].(map[string]interface{}); ok {
+        n.EstimatedCost = numberField(cost, "query_cost")
+        if n.EstimatedCost == 0 {
+            n.EstimatedCost = numberField(cost, "prefix_cost")
+        }
+    }
+    if table := stringField(obj, "table_name"); table != "" {
+        n.Operation = "table " + stringField(obj, "access_type")
+        n.Relation = table
+        n.EstimatedRows = numberField(obj, "rows_produced_per_join")
+        n.Detail = stringField(obj, "attached_condition")
+    }
+
+    keys := make([]string, 0, len(obj))
+    for key := range obj {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        switch v := obj[key].(type) {
+        case map[string]interface{}:
+            if key == "cost_info" {
+                continue
+            }
+            n.Children = append(n.Children, mysqlPlanNode(key, v))
+        case []interface{}:
+            for _, item := range v {
+                m, ok := item.(map[string]interface{})
+                if !ok {
+                    continue
+                }
+                // nested_loop items wrap a single "table" object
+                if table, ok := m["table"].(map[string]interface{}); ok && len(m) == 1 {
+                    n.Children = append(n.Children, mysqlPlanNode("table", table))
+                } else {
+                    n.Children = append(n.Children, mysqlPlanNode(key, m))
+                }
+            }
+        }
+    }
+    return n
+}
+
+func explainSQLite(ctx context.Context, conn gorm.ConnPool, sqlQuery string, args []interface{}) (*QueryPlan, error) {
+    rows, err := conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+sqlQuery, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    root := &PlanNode{Operation: "QUERY PLAN"}
+    nodes := map[int64]*PlanNode{0: root}
+    var raw strings.Builder
+    for rows.Next() {
+        var id, parent, notUsed int64
+        var detail string
+        if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+            return nil, err
+        }
+        fmt.Fprintf(&raw, "%d|%d|%s\n", id, parent, detail)
+
+        n := &PlanNode{Operation: detail, Detail: detail}
+        // Details look like "SCAN t", "SEARCH t USING INDEX ..." or "USE TEMP B-TREE FOR ORDER BY"
+        if fields := strings.Fields(detail); len(fields) >= 2 && (fields[0] == "SCAN" || fields[0] == "SEARCH") {
+            n.Operation = fields[0]
+            n.Relation = fields[1]
+            if fields[1] == "TABLE" && len(fields) >= 3 {
+                n.Relation = fields[2]
+            }
+        }
+        nodes[id] = n
+        if p, ok := nodes[parent]; ok {
+            p.Children = append(p.Children, n)
+        } else {
+            root.Children = append(root.Children, n)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return &QueryPlan{Root: root, Raw: raw.String()}, nil
+}
+
+func explainSQLServer(ctx context.Context, conn gorm.ConnPool, sqlQuery string, args []interface{}) (*QueryPlan, error) {
+    // SHOWPLAN must be the only statement in its batch and applies to the session
+    if _, err := conn.ExecContext(ctx, "SET SHOWPLAN_XML ON"); err != nil {
+        return nil, err
+    }
+    defer func() {
+        if _, err := conn.ExecContext(context.Background(), "SET SHOWPLAN_XML OFF"); err != nil {
+            // A session left in SHOWPLAN mode would return plans instead of
+            // running the next queries given to it, so it can't be pooled
+            log.Println("Error turning SHOWPLAN_XML off, discarding connection:", err)
+            if c, ok := conn.(*sql.Conn); ok {
+                c.Raw(func(interface{}) error { return driver.ErrBadConn })
+            }
+        }
+    }()
+
+    raw, err := queryText(ctx, conn, sqlQuery, args)
+    if err != nil {
+        return nil, err
+    }
+
+    var doc xmlElement
+    if err := xml.Unmarshal([]byte(raw), &doc); err != nil {
+        return nil, fmt.Errorf("unexpected SHOWPLAN output: %v", err)
+    }
+
+    plan := &QueryPlan{CostKnown: true, Raw: raw}
+    var roots []*PlanNode
+    doc.walk(func(e *xmlElement) bool {
+        if e.XMLName.Local == "StmtSimple" && plan.EstimatedRows == 0 {
+            plan.EstimatedRows, _ = strconv.ParseFloat(e.attr("StatementEstRows"), 64)
+        }
+        if e.XMLName.Local == "RelOp" {
+            roots = append(roots, sqlServerPlanNode(e))
+            return false
+        }
+        return true
+    })
+    if len(roots) == 0 {
+        return nil, fmt.Errorf("unexpected SHOWPLAN output: no operators")
+    }
+
+    plan.Root = roots[0]
+    if len(roots) > 1 {
+        plan.Root = &PlanNode{Operation: "Batch", Children: roots}
+    }
+    for _, root := range roots {
+        plan.TotalCost += root.EstimatedCost
+    }
+    plan.Root.EstimatedCost = plan.TotalCost
+    return plan, nil
+}
+
+// sqlServerPlanNode converts a showplan RelOp element. Child operators are
+// the nearest RelOp descendants; the relation comes from the nearest Object.
+func sqlServerPlanNode(relOp *xmlElement) *PlanNode {
+    n := &PlanNode{Operation: relOp.attr("PhysicalOp")}
+    if logical := relOp.attr("LogicalOp"); logical != "" && logical != n.Operation {
+        n.Detail = logical
+    }
+    n.EstimatedRows, _ = strconv.ParseFloat(relOp.attr("EstimateRows"), 64)
+    n.EstimatedCost, _ = strconv.ParseFloat(relOp.attr("EstimatedTotalSubtreeCost"), 64)
+
+    for i := range relOp.Children {
+        relOp.Children[i].walk(func(e *xmlElement) bool {
+            switch e.XMLName.Local {
+            case "RelOp":
+                n.Children = append(n.Children, sqlServerPlanNode(e))
+                return false
+            case "Object":
+                if n.Relation == "" {
+                    n.Relation = strings.Trim(e.attr("Table"), "[]")
+                }
+            }
+            return true
+        })
+    }
+    return n
+}
+
+// xmlElement is a generic XML tree used to read showplan documents.
+type xmlElement struct {
+    XMLName  xml.Name
+    Attrs    []xml.Attr   `xml:",any,attr"`
+    Children []xmlElement `xml:",any"`
+}
+
+func (e *xmlElement) attr(name string) string {
+    for _, a := range e.Attrs {
+        if a.Name.Local == name {
+            return a.Value
+        }
+    }
+    return ""
+}
+
+// walk visits e and its descendants depth first; fn returns false to skip
+// an element's children.
+func (e *xmlElement) walk(fn func(*xmlElement) bool) {
+    if !fn(e) {
+        return
+    }
+    for i := range e.Children {
+        e.Children[i].walk(fn)
+    }
+}
+
+// walkPlan visits every node of a plan tree depth first.
+func walkPlan(n *PlanNode, fn func(*PlanNode)) {
+    fn(n)
+    for _, child := range n.Children {
+        walkPlan(child, fn)
+    }
+}
+
+// stringField reads a string from decoded JSON.
+func stringField(obj map[string]interface{}, key string) string {
+    s, _ := obj[key].(string)
+    return s
+}
+
+// numberField reads a number from decoded JSON; MySQL reports costs as strings.
+func numberField(obj map[string]interface{}, key string) float64 {
+    switch v := obj[key].(type) {
+    case float64:
+        return v
+    case string:
+        f, _ := strconv.ParseFloat(v, 64)
+        return f
+    }
+    return 0
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Errors returned by SanitizeSQL. Only ErrInvalidGeneratedSQL is worth
+// sending back to the generator for repair.
+var (
+    ErrGeneratorOutput     = errors.New("generator returned an error instead of SQL")
+    ErrModelRefused        = errors.New("model declined to write SQL")
+    ErrInvalidGeneratedSQL = errors.New("generated SQL is invalid")
+)
+
+// fencePattern matches a markdown code block, optionally tagged with a language.
+var fencePattern = regexp.MustCompile("(?s)```[ \\t]*([A-Za-z0-9_-]*)[ \\t]*\\r?\\n(.*?)```")
+
+// errorOutputPattern matches error messages passed off as model output,
+// such as "An error occurred: ...".
+var errorOutputPattern = regexp.MustCompile(`(?i)^\s*(an error occurred|error)\s*:`)
+
+// refusalPattern matches the usual ways a model declines a request.
+var refusalPattern = regexp.MustCompile(`(?i)\b(sorry|i cannot|i can't|i can not|i am unable|i'm unable|unable to|as an ai|not able to|i won't)\b`)
+
+// statementKeywords are the words a generated statement may start with.
+// Generated SQL is only ever read, so anything but a query is rejected.
+var statementKeywords = map[string]bool{
+    "SELECT": true, "WITH": true,
+}
+
+// writeKeywords are words that make a statement change data, schema or
+// session state. They are rejected anywhere in a statement's code, which
+// catches data-modifying CTEs, SELECT ... INTO and locking reads.
+var writeKeywords = map[string]bool{
+    "INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true,
+    "CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+    "GRANT": true, "REVOKE": true, "INTO": true, "COPY": true, "LOCK": true,
+    "CALL": true, "EXEC": true, "EXECUTE": true, "PRAGMA": true, "ATTACH": true,
+    "DETACH": true, "VACUUM": true,
+}
+
+// SanitizeSQL turns a model reply into a single SQL statement for dialect.
+// It prefers a block fenced as sql, then any fenced block, then the first
+// line of the reply that starts a statement, and drops the surrounding prose.
+// Only a single SELECT or WITH query is accepted. The result is checked
+// lexically; the database still has the final say.
+func SanitizeSQL(output, dialect string) (string, error) {
+    if errorOutputPattern.MatchString(output) {
+        return "", fmt.Errorf("%w: %s", ErrGeneratorOutput, strings.TrimSpace(output))
+    }
+
+    sql, fenced := fencedSQL(output)
+    if !fenced {
+        sql = unfencedSQL(output)
+    }
+    sql = strings.TrimSpace(sql)
+    if sql == "" {
+        if refusalPattern.MatchString(output) {
+            return "", fmt.Errorf("%w: %s", ErrModelRefused, strings.TrimSpace(output))
+        }
+        return "", fmt.Errorf("%w: no SQL statement found", ErrInvalidGeneratedSQL)
+    }
+
+    statements, err := splitStatements(sql, dialect)
+    if err != nil {
+        return "", err
+    }
+    if len(statements) != 1 {
+        return "", fmt.Errorf("%w: expected one statement, found %d", ErrInvalidGeneratedSQL, len(statements))
+    }
+
+    statement := statements[0]
+    if keyword := firstKeyword(statement); !statementKeywords[keyword] {
+        return "", fmt.Errorf("%w: statement starts with %q", ErrInvalidGeneratedSQL, keyword)
+    }
+    if keyword := writeKeyword(statement); keyword != "" {
+        return "", fmt.Errorf("%w: only read-only queries are allowed, found %s", ErrInvalidGeneratedSQL, keyword)
+    }
+    return statement, nil
+}
+
+// fencedSQL returns the contents of the reply's sql block, or of its first
+// fenced block when none is tagged sql.
+func fencedSQL(output string) (string, bool) {
+    matches := fencePattern.FindAllStringSubmatch(output, -1)
+    for _, m := range matches {
+        if strings.EqualFold(m[1], "sql") {
+            return m[2], true
+        }
+    }
+    if len(matches) > 0 {
+        return matches[0][2], true
+    }
+    return "", false
+}
+
+// unfencedSQL returns the reply from the first line that starts a statement
+// up to a blank line or a line ending in a semicolon that isn't followed by
+// another statement, or "" when no line starts one.
+func unfencedSQL(output string) string {
+    lines := strings.Split(output, "\n")
+    for i, line := range lines {
+        // Replies often label the query, as in "SQL query: SELECT ..."
+        if label, rest, ok := strings.Cut(line, ":"); ok && !statementKeywords[firstKeyword(line)] && strings.Contains(strings.ToLower(label), "sql") {
+            line = rest
+        }
+        if !statementKeywords[firstKeyword(line)] {
+            continue
+        }
+
+        body := []string{line}
+        for j := i + 1; j < len(lines); j++ {
+            previous := strings.TrimSpace(body[len(body)-1])
+            if strings.HasSuffix(previous, ";") && !statementKeywords[firstKeyword(lines[j])] {
+                break
+            }
+            if strings.TrimSpace(lines[j]) == "" {
+                break
+            }
+            body = append(body, lines[j])
+        }
+        return strings.Join(body, "\n")
+    }
+    return ""
+}
+
+// firstKeyword returns the first word of a statement's code, upper-cased.
+func firstKeyword(sql string) string {
+    for _, span := range splitSQLSpans(sql) {
+        text := strings.TrimSpace(span.Text)
+        if span.Kind == spanComment || text == "" {
+            continue
+        }
+        if span.Kind != spanCode {
+            return ""
+        }
+        end := strings.IndexFunc(text, func(r rune) bool {
+            return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+        })
+        if end < 0 {
+            end = len(text)
+        }
+        return strings.ToUpper(text[:end])
+    }
+    return ""
+}
+
+// writeKeyword returns the first word of writeKeywords found in the code of
+// sql, upper-cased, or "" when there is none. Literals, quoted identifiers
+// and comments are skipped.
+func writeKeyword(sql string) string {
+    for _, span := range splitSQLSpans(sql) {
+        if span.Kind != spanCode {
+            continue
+        }
+        words := strings.FieldsFunc(span.Text, func(r rune) bool {
+            return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+        })
+        for _, word := range words {
+            if word = strings.ToUpper(word); writeKeywords[word] {
+                return word
+            }
+        }
+    }
+    return ""
+}
+
+// splitStatements splits SQL on top-level semicolons, dropping empty
+// statements, and rejects unterminated literals, unbalanced parentheses and
+// quoting the dialect doesn't support.
+func splitStatements(sql, dialect string) ([]string, error) {
+    var statements []string
+    var current strings.Builder
+    depth := 0
+    flush := func() {
+        if s := strings.TrimSpace(current.String()); s != "" && hasCode(s) {
+            statements = append(statements, s)
+        }
+        current.Reset()
+    }
+
+    for _, span := range splitSQLSpans(sql) {
+        if !spanClosed(span) {
+            return nil, fmt.Errorf("%w: unterminated %s", ErrInvalidGeneratedSQL, spanDescription(span))
+        }
+        if span.Kind == spanIdent {
+            if err := checkIdentQuote(span.Text[0], dialect); err != nil {
+                return nil, err
+            }
+        }
+        if span.Kind != spanCode {
+            current.WriteString(span.Text)
+            continue
+        }
+
+        for i := 0; i < len(span.Text); i++ {
+            switch c := span.Text[i]; c {
+            case '(':
+                depth++
+            case ')':
+                depth--
+                if depth < 0 {
+                    return nil, fmt.Errorf("%w: unbalanced parentheses", ErrInvalidGeneratedSQL)
+                }
+            case ';':
+                if depth == 0 {
+                    flush()
+                    continue
+                }
+            }
+            current.WriteByte(span.Text[i])
+        }
+    }
+    if depth != 0 {
+        return nil, fmt.Errorf("%w: unbalanced parentheses", ErrInvalidGeneratedSQL)
+    }
+    flush()
+    return statements, nil
+}
+
+// hasCode reports whether SQL contains anything besides comments.
+func hasCode(sql string) bool {
+    for _, span := range splitSQLSpans(sql) {
+        if span.Kind != spanComment && strings.TrimSpace(span.Text) != "" {
+            return true
+        }
+    }
+    return false
+}
+
+// spanClosed reports whether a literal, identifier or comment span has its
+// closing delimiter; splitSQLSpans lets unterminated ones run to the end.
+func spanClosed(span sqlSpan) bool {
+    text := span.Text
+    switch span.Kind {
+    case spanString, spanIdent:
+        if tag, ok := dollarQuoteTag(text); ok {
+            return len(text) >= 2*len(tag) && strings.HasSuffix(text, tag)
+        }
+        close := text[0]
+        if close == '[' {
+            close = ']'
+        }
+        for i := 1; i < len(text); i++ {
+            if text[i] != close {
+                continue
+            }
+            if i+1 < len(text) && text[i+1] == close {
+                i++
+                continue
+            }
+            return i == len(text)-1
+        }
+        return false
+    case spanComment:
+        return strings.HasPrefix(text, "--") || len(text) >= 4 && strings.HasSuffix(text, "*/")
+    }
+    return true
+}
+
+func spanDescription(span sqlSpan) string {
+    switch span.Kind {
+    case spanString:
+        return "string literal"
+    case spanIdent:
+        return "quoted identifier"
+    }
+    return "comment"
+}
+
+// checkIdentQuote rejects identifier quoting the dialect doesn't accept.
+// Brackets are left alone for Postgres, where they are array subscripts.
+func checkIdentQuote(quote byte, dialect string) error {
+    switch {
+    case quote == '`' && (dialect == "postgres" || dialect == "sqlserver"):
+        return fmt.Errorf("%w: backtick-quoted identifiers are not valid in %s", ErrInvalidGeneratedSQL, dialect)
+    case quote == '[' && dialect == "mysql":
+        return fmt.Errorf("%w: bracket-quoted identifiers are not valid in %s", ErrInvalidGeneratedSQL, dialect)
+    }
+    return nil
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+    tests := []struct {
+        name    string
+        output  string
+        dialect string
+        want    string
+    }{
+        {"bare statement", "SELECT * FROM users", "postgres", "SELECT * FROM users"},
+        {"trailing semicolon", "SELECT * FROM users;\n", "postgres", "SELECT * FROM users"},
+        {"sql fence with prose",
+            "Sure! Here is the query:\n\n```sql\nSELECT name\nFROM users\nWHERE country = 'NZ';\n```\n\nThis returns every user in New Zealand.",
+            "postgres", "SELECT name\nFROM users\nWHERE country = 'NZ'"},
+        {"sql fence preferred over other fences",
+            "```text\nnot sql\n```\n```SQL\nSELECT 1\n```", "mysql", "SELECT 1"},
+        {"untagged fence", "```\nSELECT COUNT(*) FROM orders\n```", "sqlite", "SELECT COUNT(*) FROM orders"},
+        {"labelled line", "SQL query: SELECT id FROM users", "postgres", "SELECT id FROM users"},
+        {"prose before and after",
+            "To answer this, run\nSELECT id\nFROM users;\nIt lists the IDs.", "postgres", "SELECT id\nFROM users"},
+        {"common table expression", "WITH t AS (SELECT 1 AS x) SELECT x FROM t", "postgres", "WITH t AS (SELECT 1 AS x) SELECT x FROM t"},
+        {"semicolon inside a literal", "SELECT 'a;b' AS s", "postgres", "SELECT 'a;b' AS s"},
+        {"comment line before an unfenced statement", "-- users per country\nSELECT country, COUNT(*) FROM users GROUP BY country", "postgres",
+            "SELECT country, COUNT(*) FROM users GROUP BY country"},
+        {"comment inside a fence", "```sql\n-- users per country\nSELECT country FROM users\n```", "postgres",
+            "-- users per country\nSELECT country FROM users"},
+        {"backticks on mysql", "SELECT `name` FROM `users`", "mysql", "SELECT `name` FROM `users`"},
+        {"brackets on sqlserver", "SELECT TOP 5 [name] FROM [users]", "sqlserver", "SELECT TOP 5 [name] FROM [users]"},
+        {"array subscript on postgres", "SELECT tags[1] FROM posts", "postgres", "SELECT tags[1] FROM posts"},
+        {"write keywords in literals and quoted identifiers", "SELECT \"update\", 'DROP TABLE x' FROM audit -- delete\nWHERE action = 'DELETE'", "postgres",
+            "SELECT \"update\", 'DROP TABLE x' FROM audit -- delete\nWHERE action = 'DELETE'"},
+        {"identifiers containing write keywords", "SELECT updated_at, created_by FROM users", "postgres", "SELECT updated_at, created_by FROM users"},
+    }
+    for _, tt := range tests {
+        got, err := SanitizeSQL(tt.output, tt.dialect)
+        if err != nil {
+            t.Errorf("%s: SanitizeSQL failed: %v", tt.name, err)
+            continue
+        }
+        if got != tt.want {
+            t.Errorf("%s: SanitizeSQL = %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestSanitizeSQLRejects(t *testing.T) {
+    tests := []struct {
+        name    string
+        output  string
+        dialect string
+        want    error
+    }{
+        {"error string", "An error occurred: rate limit exceeded", "postgres", ErrGeneratorOutput},
+        {"error prefix", "Error: model overloaded", "postgres", ErrGeneratorOutput},
+        {"refusal", "I'm sorry, but I can't help with that request.", "postgres", ErrModelRefused},
+        {"prose only", "The users table holds one row per customer.", "postgres", ErrInvalidGeneratedSQL},
+        {"empty", "", "postgres", ErrInvalidGeneratedSQL},
+        {"two statements", "SELECT 1; SELECT 2;", "postgres", ErrInvalidGeneratedSQL},
+        {"two statements in a fence", "```sql\nDELETE FROM users;\nSELECT 1;\n```", "mysql", ErrInvalidGeneratedSQL},
+        {"unterminated literal", "SELECT 'abc FROM users", "postgres", ErrInvalidGeneratedSQL},
+        {"unterminated comment", "SELECT 1 /* trailing", "postgres", ErrInvalidGeneratedSQL},
+        {"unbalanced parentheses", "SELECT COUNT(* FROM users", "postgres", ErrInvalidGeneratedSQL},
+        {"extra closing parenthesis", "SELECT 1) FROM users", "postgres", ErrInvalidGeneratedSQL},
+        {"not a statement", "```sql\nusers.name\n```", "postgres", ErrInvalidGeneratedSQL},
+        {"backticks on postgres", "SELECT `name` FROM users", "postgres", ErrInvalidGeneratedSQL},
+        {"backticks on sqlserver", "SELECT `name` FROM users", "sqlserver", ErrInvalidGeneratedSQL},
+        {"brackets on mysql", "SELECT [name] FROM users", "mysql", ErrInvalidGeneratedSQL},
+        {"drop table", "DROP TABLE x", "postgres", ErrInvalidGeneratedSQL},
+        {"fenced delete", "```sql\nDELETE FROM users\n```", "postgres", ErrInvalidGeneratedSQL},
+        {"insert", "INSERT INTO users (name) VALUES ('x')", "mysql", ErrInvalidGeneratedSQL},
+        {"data-modifying CTE", "WITH d AS (DELETE FROM users RETURNING id) SELECT * FROM d", "postgres", ErrInvalidGeneratedSQL},
+        {"update in a CTE", "WITH u AS (UPDATE users SET name = 'x' RETURNING id) SELECT COUNT(*) FROM u", "postgres", ErrInvalidGeneratedSQL},
+        {"select into", "SELECT * INTO backup FROM users", "sqlserver", ErrInvalidGeneratedSQL},
+        {"locking read", "SELECT * FROM users FOR UPDATE", "mysql", ErrInvalidGeneratedSQL},
+        {"pragma", "PRAGMA writable_schema = ON", "sqlite", ErrInvalidGeneratedSQL},
+        {"exec", "```sql\nEXEC sp_who\n```", "sqlserver", ErrInvalidGeneratedSQL},
+    }
+    for _, tt := range tests {
+        got, err := SanitizeSQL(tt.output, tt.dialect)
+        if !errors.Is(err, tt.want) {
+            t.Errorf("%s: SanitizeSQL = %q, %v; want error %v", tt.name, got, err, tt.want)
+        }
+    }
+}
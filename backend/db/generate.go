@@ -0,0 +1,179 @@
+package db
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+// Generator turns a natural-language question into SQL, and explains SQL
+// and results in plain English. GenerateSQL returns the model's reply as
+// is; SanitizeSQL extracts the statement.
+type Generator interface {
+    GenerateSQL(ctx context.Context, req GenerateRequest) (string, error)
+    ExplainSQL(ctx context.Context, req ExplainSQLRequest) (string, error)
+    SummarizeResult(ctx context.Context, req SummarizeRequest) (string, error)
+}
+
+// ExplainSQLRequest asks for a plain-English description of a query.
+type ExplainSQLRequest struct {
+    SQL     string
+    Dialect string // May be empty when no connection is involved
+}
+
+// SummarizeRequest asks for a short narrative of a query's result.
+type SummarizeRequest struct {
+    Question string // May be empty for queries written by hand
+    SQL      string
+    Rows     []map[string]interface{}
+}
+
+// GenerateRequest is everything a generator is told about a question.
+type GenerateRequest struct {
+    Question      string
+    Dialect       string              // The connection's driver name
+    ServerVersion string              // As reported by ServerVersion; may be empty
+    Schema        string              // Table and column listing from DescribeSchema
+    Examples      []PromptExample     // Known-good pairs for this database, most similar first
+    Semantics     *SemanticResolution // Business definitions the question refers to
+    Conversation  []ConversationTurn  // Earlier questions in the session, oldest first
+    Previous      []GenerationAttempt // Earlier failed attempts, oldest first, for repair
+}
+
+// GenerationAttempt is one round of the generate-and-validate loop.
+type GenerationAttempt struct {
+    SQL    string `json:"sql"`
+    Output string `json:"output,omitempty"` // The raw reply, when no usable SQL could be extracted
+    Error  string `json:"error,omitempty"`
+}
+
+// ErrGenerationFailed is returned when no attempt produced valid SQL.
+var ErrGenerationFailed = errors.New("could not generate valid SQL")
+
+// repairableErrors are fragments of database errors that a generator can
+// fix given the message and the schema: syntax mistakes and references to
+// tables or columns that don't exist.
+var repairableErrors = []string{
+    "syntax",
+    "does not exist",
+    "doesn't exist",
+    "unknown column",
+    "unknown table",
+    "no such column",
+    "no such table",
+    "no such function",
+    "invalid column name",
+    "invalid object name",
+    "ambiguous",
+    "must appear in the group by",
+    "incomplete input",
+}
+
+// IsRepairableError reports whether a query error is worth sending back to
+// the generator rather than failing outright.
+func IsRepairableError(err error) bool {
+    if err == nil {
+        return false
+    }
+    msg := strings.ToLower(err.Error())
+    for _, fragment := range repairableErrors {
+        if strings.Contains(msg, fragment) {
+            return true
+        }
+    }
+    return false
+}
+
+// DescribeSchema lists the tables of a database and their columns, one
+// table per line, for use in generation prompts.
+func DescribeSchema(db *gorm.DB) (string, error) {
+    migrator := db.Migrator()
+    tables, err := migrator.GetTables()
+    if err != nil {
+        return "", err
+    }
+
+    var b strings.Builder
+    for _, table := range tables {
+        columns, err := migrator.ColumnTypes(table)
+        if err != nil {
+            log.Printf("Error describing table %s: %v", table, err)
+            continue
+        }
+
+        parts := make([]string, 0, len(columns))
+        for _, column := range columns {
+            part := column.Name() + " " + strings.ToLower(column.DatabaseTypeName())
+            if primary, ok := column.PrimaryKey(); ok && primary {
+                part += " primary key"
+            }
+            parts = append(parts, part)
+        }
+        fmt.Fprintf(&b, "%s(%s)\n", table, strings.Join(parts, ", "))
+    }
+    return b.String(), nil
+}
+
+// GenerateValidSQL asks gen for SQL answering req.Question and dry-runs each
+// attempt with EXPLAIN. SanitizeSQL rejects anything but a single read-only
+// query before it reaches the database. Attempts that fail with a repairable error are fed
+// back to the generator, up to maxAttempts in total. The dialect and schema
+// are filled in from db when req leaves them empty. It returns the SQL that
+// passed validation along with every attempt made.
+func GenerateValidSQL(ctx context.Context, gen Generator, db *gorm.DB, req GenerateRequest, maxAttempts int) (string, []GenerationAttempt, error) {
+    if req.Dialect == "" {
+        req.Dialect = db.Dialector.Name()
+    }
+    if req.Schema == "" {
+        schema, err := DescribeSchema(db)
+        if err != nil {
+            log.Println("Error describing schema:", err)
+        }
+        req.Schema = schema
+    }
+
+    var attempts []GenerationAttempt
+    for len(attempts) < maxAttempts {
+        output, err := gen.GenerateSQL(ctx, req)
+        if err != nil {
+            return "", attempts, err
+        }
+
+        var attempt GenerationAttempt
+        attempt.SQL, err = SanitizeSQL(output, req.Dialect)
+        if err != nil {
+            attempt.Output = output
+            attempt.Error = err.Error()
+            attempts = append(attempts, attempt)
+            switch {
+            case errors.Is(err, ErrInvalidGeneratedSQL):
+                req.Previous = attempts
+                continue
+            case errors.Is(err, ErrModelRefused):
+                return "", attempts, fmt.Errorf("%w: %v", ErrGenerationFailed, err)
+            }
+            return "", attempts, err
+        }
+
+        _, err = ExplainQuery(ctx, db, attempt.SQL)
+        if err == nil {
+            attempts = append(attempts, attempt)
+            return attempt.SQL, attempts, nil
+        }
+        attempt.Error = err.Error()
+        attempts = append(attempts, attempt)
+
+        if ctx.Err() != nil {
+            return "", attempts, ctx.Err()
+        }
+        if !IsRepairableError(err) {
+            return "", attempts, fmt.Errorf("%w: %v", ErrGenerationFailed, err)
+        }
+        req.Previous = attempts
+    }
+    return "", attempts, fmt.Errorf("%w after %d attempts", ErrGenerationFailed, len(attempts))
+}
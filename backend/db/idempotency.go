@@ -0,0 +1,82 @@
+package db
+
+import (
+    "errors"
+    "log"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Errors returned by ClaimIdempotencyKey.
+var (
+    ErrIdempotencyKeyReused     = errors.New("idempotency key was used for a different request")
+    ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// ClaimIdempotencyKey looks up a key for an endpoint. If the key is new, or
+// its record is older than ttl, it is claimed for this request and returned
+// with claimed set; the caller then runs the request and calls
+// CompleteIdempotencyKey or ReleaseIdempotencyKey. Otherwise the completed
+// record is returned so its response can be replayed.
+//
+// A claim holds the key for lease only: a request still in progress after
+// that (one whose server crashed, say) no longer blocks retries, which
+// claim the key afresh.
+func ClaimIdempotencyKey(db *gorm.DB, endpoint, key, requestHash string, ttl, lease time.Duration) (record *IdempotencyKey, claimed bool, err error) {
+    var existing IdempotencyKey
+    err = db.Where("endpoint = ? AND idempotency_key = ?", endpoint, key).First(&existing).Error
+    switch {
+    case err == nil && (existing.CreatedAt.Before(time.Now().Add(-ttl)) ||
+        existing.StatusCode == 0 && existing.CreatedAt.Before(time.Now().Add(-lease))):
+        // The record's CreatedAt is when the key was claimed
+        if err := db.Delete(&existing).Error; err != nil {
+            return nil, false, err
+        }
+    case err == nil && existing.RequestHash != requestHash:
+        return nil, false, ErrIdempotencyKeyReused
+    case err == nil && existing.StatusCode == 0:
+        return nil, false, ErrIdempotencyKeyInProgress
+    case err == nil:
+        return &existing, false, nil
+    case !errors.Is(err, gorm.ErrRecordNotFound):
+        log.Println("Error fetching idempotency key:", err)
+        return nil, false, err
+    }
+
+    record = &IdempotencyKey{Endpoint: endpoint, Key: key, RequestHash: requestHash}
+    if err := db.Create(record).Error; err != nil {
+        // Another request claimed the key between the lookup and the insert
+        var count int64
+        if db.Model(&IdempotencyKey{}).Where("endpoint = ? AND idempotency_key = ?", endpoint, key).Count(&count); count > 0 {
+            return nil, false, ErrIdempotencyKeyInProgress
+        }
+        log.Println("Error claiming idempotency key:", err)
+        return nil, false, err
+    }
+    return record, true, nil
+}
+
+// CompleteIdempotencyKey stores the response to a claimed key's request.
+func CompleteIdempotencyKey(db *gorm.DB, record *IdempotencyKey, statusCode int, contentType, response string) error {
+    err := db.Model(record).Updates(map[string]interface{}{
+        "status_code":  statusCode,
+        "content_type": contentType,
+        "response":     response,
+    }).Error
+    if err != nil {
+        log.Println("Error saving idempotent response:", err)
+        return err
+    }
+    return nil
+}
+
+// ReleaseIdempotencyKey forgets a claimed key whose request failed in a way
+// worth retrying, so a retry runs the request again.
+func ReleaseIdempotencyKey(db *gorm.DB, record *IdempotencyKey) error {
+    if err := db.Delete(record).Error; err != nil {
+        log.Println("Error releasing idempotency key:", err)
+        return err
+    }
+    return nil
+}
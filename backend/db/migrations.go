@@ -0,0 +1,617 @@
+package db
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// ErrSchemaOutOfDate is returned by CheckSchema when the database does not
+// match the migrations compiled into this binary.
+var ErrSchemaOutOfDate = errors.New("database schema is out of date")
+
+// Migration is a single versioned schema change. Up applies it and Down
+// reverts it; both run inside the same transaction that records the version.
+type Migration struct {
+    Version int
+    Name    string
+    Up      func(tx *gorm.DB) error
+    Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is a row of the schema_migrations table, one per applied migration.
+type SchemaMigration struct {
+    Version   int       `gorm:"primaryKey;autoIncrement:false"`
+    Name      string    `gorm:"not null"`
+    AppliedAt time.Time `gorm:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+    return "schema_migrations"
+}
+
+// MigrationState describes whether a known migration has been applied.
+type MigrationState struct {
+    Version   int        `json:"version"`
+    Name      string     `json:"name"`
+    Applied   bool       `json:"applied"`
+    AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// The structs below are snapshots of the models at the time each migration
+// was written. Migrations must not reference the live models in models.go,
+// otherwise editing a model would silently change what an old migration does.
+
+type userV1 struct {
+    ID        int       `gorm:"primaryKey"`
+    Name      string
+    Email     string    `gorm:"unique;not null"`
+    Password  string    `gorm:"not null"`
+    Country   string
+    State     string
+    CreatedAt time.Time
+    UpdatedAt time.Time
+}
+
+func (userV1) TableName() string { return "users" }
+
+type transactionV1 struct {
+    ID     int       `gorm:"primaryKey"`
+    UserID int       `gorm:"not null;index"`
+    Amount float64   `gorm:"not null"`
+    Date   time.Time
+    User   userV1    `gorm:"foreignKey:UserID"`
+}
+
+func (transactionV1) TableName() string { return "transactions" }
+
+type sessionV1 struct {
+    ID        int    `gorm:"primaryKey"`
+    UserID    int    `gorm:"not null;index"`
+    Token     string `gorm:"not null;index"`
+    ExpiresAt time.Time
+    User      userV1 `gorm:"foreignKey:UserID"`
+}
+
+func (sessionV1) TableName() string { return "sessions" }
+
+type queryHistoryV4 struct {
+    ID           int       `gorm:"primaryKey"`
+    UserID       int       `gorm:"not null;index"`
+    Question     string
+    GeneratedSQL string
+    ExecutedSQL  string    `gorm:"not null"`
+    ConnectionID string    `gorm:"index"`
+    Driver       string
+    DurationMS   int64
+    RowCount     int64
+    Error        string
+    CreatedAt    time.Time `gorm:"index"`
+    User         userV1    `gorm:"foreignKey:UserID"`
+}
+
+func (queryHistoryV4) TableName() string { return "query_histories" }
+
+type savedQueryV5 struct {
+    ID          int    `gorm:"primaryKey"`
+    UserID      int    `gorm:"not null;index"`
+    Name        string `gorm:"not null"`
+    Description string
+    SQLQuery    string `gorm:"not null"`
+    Driver      string
+    Parameters  string `gorm:"type:text"`
+    CreatedAt   time.Time
+    UpdatedAt   time.Time
+    User        userV1 `gorm:"foreignKey:UserID"`
+}
+
+func (savedQueryV5) TableName() string { return "saved_queries" }
+
+type queryHistoryV6 struct {
+    Arguments string `gorm:"type:text"`
+}
+
+func (queryHistoryV6) TableName() string { return "query_histories" }
+
+type sqlExampleV7 struct {
+    ID            int    `gorm:"primaryKey"`
+    UserID        int    `gorm:"not null;index"`
+    ConnectionKey string `gorm:"not null;index"`
+    Driver        string
+    Question      string `gorm:"not null"`
+    SQLQuery      string `gorm:"not null"`
+    HistoryID     *int
+    CreatedAt     time.Time
+    User          userV1 `gorm:"foreignKey:UserID"`
+}
+
+func (sqlExampleV7) TableName() string { return "sql_examples" }
+
+type semanticLayerV8 struct {
+    ID            int    `gorm:"primaryKey"`
+    ConnectionKey string `gorm:"not null;uniqueIndex"`
+    Definition    string `gorm:"type:text"`
+    UpdatedByID   int
+    CreatedAt     time.Time
+    UpdatedAt     time.Time
+}
+
+func (semanticLayerV8) TableName() string { return "semantic_layers" }
+
+type conversationTurnV9 struct {
+    ID            int    `gorm:"primaryKey"`
+    SessionID     int    `gorm:"not null;index"`
+    ConnectionKey string `gorm:"not null"`
+    Question      string `gorm:"not null"`
+    SQLQuery      string `gorm:"not null"`
+    Columns       string `gorm:"type:text"`
+    RowCount      int64
+    CreatedAt     time.Time
+    Session       sessionV1 `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
+}
+
+func (conversationTurnV9) TableName() string { return "conversation_turns" }
+
+type transactionV10 struct {
+    AmountMinor int64  `gorm:"not null;default:0"`
+    Currency    string `gorm:"size:3;not null;default:'USD'"`
+    Category    string `gorm:"size:64;index"`
+    Merchant    string `gorm:"size:128"`
+    Description string `gorm:"size:255"`
+    Status      string `gorm:"size:16;not null;default:'posted';index"`
+    ExternalRef string `gorm:"size:128;index"`
+}
+
+func (transactionV10) TableName() string { return "transactions" }
+
+// transactionAmountV1 holds the float amount while migration 10 is reverted.
+type transactionAmountV1 struct {
+    AmountMajor float64 `gorm:"not null;default:0"`
+}
+
+func (transactionAmountV1) TableName() string { return "transactions" }
+
+type transactionV11 struct {
+    UserID int       `gorm:"index"`
+    Amount int64     `gorm:"index"`
+    Date   time.Time `gorm:"index"`
+}
+
+func (transactionV11) TableName() string { return "transactions" }
+
+type idempotencyKeyV12 struct {
+    ID          int    `gorm:"primaryKey"`
+    Endpoint    string `gorm:"size:64;not null;uniqueIndex:idx_idempotency_keys_endpoint_key"`
+    Key         string `gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_keys_endpoint_key"`
+    RequestHash string `gorm:"not null"`
+    StatusCode  int
+    ContentType string
+    Response    string    `gorm:"type:text"`
+    CreatedAt   time.Time `gorm:"index"`
+}
+
+func (idempotencyKeyV12) TableName() string { return "idempotency_keys" }
+
+type userV13 struct {
+    DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (userV13) TableName() string { return "users" }
+
+type transactionV13 struct {
+    DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (transactionV13) TableName() string { return "transactions" }
+
+type sessionV13 struct {
+    DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (sessionV13) TableName() string { return "sessions" }
+
+type queryHistoryV14 struct {
+    ConnectionKey string `gorm:"index"`
+}
+
+func (queryHistoryV14) TableName() string { return "query_histories" }
+
+// migrations is the ordered migration history. Append new migrations to the
+// end with the next version number; never edit or reorder applied ones.
+var migrations = []Migration{
+    {
+        Version: 1,
+        Name:    "create_users",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&userV1{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&userV1{})
+        },
+    },
+    {
+        Version: 2,
+        Name:    "create_transactions",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&transactionV1{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&transactionV1{})
+        },
+    },
+    {
+        Version: 3,
+        Name:    "create_sessions",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&sessionV1{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&sessionV1{})
+        },
+    },
+    {
+        Version: 4,
+        Name:    "create_query_histories",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&queryHistoryV4{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&queryHistoryV4{})
+        },
+    },
+    {
+        Version: 5,
+        Name:    "create_saved_queries",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&savedQueryV5{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&savedQueryV5{})
+        },
+    },
+    {
+        Version: 6,
+        Name:    "add_query_history_arguments",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().AddColumn(&queryHistoryV6{}, "Arguments")
+        },
+        Down: func(tx *gorm.DB) error {
+            return dropColumn(tx, &queryHistoryV6{}, "Arguments")
+        },
+    },
+    {
+        Version: 7,
+        Name:    "create_sql_examples",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&sqlExampleV7{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&sqlExampleV7{})
+        },
+    },
+    {
+        Version: 8,
+        Name:    "create_semantic_layers",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&semanticLayerV8{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&semanticLayerV8{})
+        },
+    },
+    {
+        Version: 9,
+        Name:    "create_conversation_turns",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&conversationTurnV9{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&conversationTurnV9{})
+        },
+    },
+    {
+        // Existing amounts were floats in major units with no currency; they
+        // become integer cents of USD and count as posted.
+        Version: 10,
+        Name:    "add_transaction_details",
+        Up: func(tx *gorm.DB) error {
+            m := tx.Migrator()
+            for _, field := range []string{"AmountMinor", "Currency", "Category", "Merchant", "Description", "Status", "ExternalRef"} {
+                if err := m.AddColumn(&transactionV10{}, field); err != nil {
+                    return err
+                }
+            }
+            if err := tx.Exec("UPDATE transactions SET amount_minor = ROUND(amount * 100)").Error; err != nil {
+                return err
+            }
+            if err := m.DropColumn(&transactionV1{}, "Amount"); err != nil {
+                return err
+            }
+            if err := m.RenameColumn(&transactionV10{}, "amount_minor", "amount"); err != nil {
+                return err
+            }
+            for _, field := range []string{"Category", "Status", "ExternalRef"} {
+                if err := m.CreateIndex(&transactionV10{}, field); err != nil {
+                    return err
+                }
+            }
+            return nil
+        },
+        Down: func(tx *gorm.DB) error {
+            m := tx.Migrator()
+            for _, field := range []string{"Category", "Status", "ExternalRef"} {
+                if err := m.DropIndex(&transactionV10{}, field); err != nil {
+                    return err
+                }
+            }
+            if err := m.AddColumn(&transactionAmountV1{}, "AmountMajor"); err != nil {
+                return err
+            }
+            // Amounts go back to major units, which depends on the currency.
+            // The minor units are frozen as they were when this migration
+            // was written, so later changes to CurrencyExponent don't alter it.
+            scale := "CASE" +
+                " WHEN currency IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG', 'RWF', 'UGX', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1.0" +
+                " WHEN currency IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000.0" +
+                " ELSE 100.0 END"
+            if err := tx.Exec("UPDATE transactions SET amount_major = amount / " + scale).Error; err != nil {
+                return err
+            }
+            for _, field := range []string{"Currency", "Category", "Merchant", "Description", "Status", "ExternalRef"} {
+                if err := m.DropColumn(&transactionV10{}, field); err != nil {
+                    return err
+                }
+            }
+            if err := m.DropColumn(&transactionV10{}, "amount"); err != nil {
+                return err
+            }
+            return m.RenameColumn(&transactionAmountV1{}, "amount_major", "amount")
+        },
+    },
+    {
+        Version: 11,
+        Name:    "index_transaction_sort_columns",
+        Up: func(tx *gorm.DB) error {
+            for _, field := range []string{"Date", "Amount", "UserID"} {
+                if err := tx.Migrator().CreateIndex(&transactionV11{}, field); err != nil {
+                    return err
+                }
+            }
+            return nil
+        },
+        Down: func(tx *gorm.DB) error {
+            for _, field := range []string{"UserID", "Amount", "Date"} {
+                if err := tx.Migrator().DropIndex(&transactionV11{}, field); err != nil {
+                    return err
+                }
+            }
+            return nil
+        },
+    },
+    {
+        Version: 12,
+        Name:    "create_idempotency_keys",
+        Up: func(tx *gorm.DB) error {
+            return tx.Migrator().CreateTable(&idempotencyKeyV12{})
+        },
+        Down: func(tx *gorm.DB) error {
+            return tx.Migrator().DropTable(&idempotencyKeyV12{})
+        },
+    },
+    {
+        Version: 13,
+        Name:    "add_soft_delete",
+        Up: func(tx *gorm.DB) error {
+            for _, model := range []interface{}{&userV13{}, &transactionV13{}, &sessionV13{}} {
+                if err := tx.Migrator().AddColumn(model, "DeletedAt"); err != nil {
+                    return err
+                }
+                if err := tx.Migrator().CreateIndex(model, "DeletedAt"); err != nil {
+                    return err
+                }
+            }
+            return nil
+        },
+        Down: func(tx *gorm.DB) error {
+            for _, model := range []interface{}{&sessionV13{}, &transactionV13{}, &userV13{}} {
+                if err := tx.Migrator().DropIndex(model, "DeletedAt"); err != nil {
+                    return err
+                }
+                if err := dropColumn(tx, model, "DeletedAt"); err != nil {
+                    return err
+                }
+            }
+            return nil
+        },
+    },
+    {
+        Version: 14,
+        Name:    "add_query_history_connection_key",
+        Up: func(tx *gorm.DB) error {
+            if err := tx.Migrator().AddColumn(&queryHistoryV14{}, "ConnectionKey"); err != nil {
+                return err
+            }
+            return tx.Migrator().CreateIndex(&queryHistoryV14{}, "ConnectionKey")
+        },
+        Down: func(tx *gorm.DB) error {
+            if err := tx.Migrator().DropIndex(&queryHistoryV14{}, "ConnectionKey"); err != nil {
+                return err
+            }
+            return dropColumn(tx, &queryHistoryV14{}, "ConnectionKey")
+        },
+    },
+}
+
+func init() {
+    sort.Slice(migrations, func(i, j int) bool {
+        return migrations[i].Version < migrations[j].Version
+    })
+    for i := 1; i < len(migrations); i++ {
+        if migrations[i].Version == migrations[i-1].Version {
+            panic(fmt.Sprintf("duplicate migration version %d", migrations[i].Version))
+        }
+    }
+}
+
+// dropColumn drops a column. SQLite can't drop columns in place, so GORM
+// rebuilds the table there and its indexes are lost; they are recreated
+// afterwards. Indexes on the dropped column must be dropped first.
+func dropColumn(tx *gorm.DB, model interface{}, column string) error {
+    if tx.Dialector.Name() != "sqlite" {
+        return tx.Migrator().DropColumn(model, column)
+    }
+
+    stmt := &gorm.Statement{DB: tx}
+    if err := stmt.Parse(model); err != nil {
+        return err
+    }
+    var indexes []string
+    if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", stmt.Table).Scan(&indexes).Error; err != nil {
+        return err
+    }
+    if err := tx.Migrator().DropColumn(model, column); err != nil {
+        return err
+    }
+    for _, index := range indexes {
+        if err := tx.Exec(index).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ensureMigrationsTable creates the schema_migrations table if it is missing.
+func ensureMigrationsTable(db *gorm.DB) error {
+    if db.Migrator().HasTable(&SchemaMigration{}) {
+        return nil
+    }
+    return db.Migrator().CreateTable(&SchemaMigration{})
+}
+
+// appliedMigrations returns the applied migrations keyed by version.
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+    if err := ensureMigrationsTable(db); err != nil {
+        return nil, err
+    }
+    var rows []SchemaMigration
+    if err := db.Order("version").Find(&rows).Error; err != nil {
+        return nil, err
+    }
+    applied := make(map[int]SchemaMigration, len(rows))
+    for _, row := range rows {
+        applied[row.Version] = row
+    }
+    return applied, nil
+}
+
+// MigrateUp applies every pending migration in version order.
+func MigrateUp(db *gorm.DB) error {
+    applied, err := appliedMigrations(db)
+    if err != nil {
+        log.Println("Error reading migration history:", err)
+        return err
+    }
+
+    for _, m := range migrations {
+        if _, ok := applied[m.Version]; ok {
+            continue
+        }
+        log.Printf("Applying migration %d_%s", m.Version, m.Name)
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := m.Up(tx); err != nil {
+                return err
+            }
+            return tx.Create(&SchemaMigration{
+                Version:   m.Version,
+                Name:      m.Name,
+                AppliedAt: time.Now().UTC(),
+            }).Error
+        })
+        if err != nil {
+            log.Printf("Error applying migration %d_%s: %v", m.Version, m.Name, err)
+            return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+        }
+    }
+    return nil
+}
+
+// MigrateDown reverts the most recently applied steps migrations.
+func MigrateDown(db *gorm.DB, steps int) error {
+    applied, err := appliedMigrations(db)
+    if err != nil {
+        log.Println("Error reading migration history:", err)
+        return err
+    }
+
+    for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+        m := migrations[i]
+        if _, ok := applied[m.Version]; !ok {
+            continue
+        }
+        log.Printf("Reverting migration %d_%s", m.Version, m.Name)
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := m.Down(tx); err != nil {
+                return err
+            }
+            return tx.Delete(&SchemaMigration{}, m.Version).Error
+        })
+        if err != nil {
+            log.Printf("Error reverting migration %d_%s: %v", m.Version, m.Name, err)
+            return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+        }
+        steps--
+    }
+    return nil
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+    applied, err := appliedMigrations(db)
+    if err != nil {
+        log.Println("Error reading migration history:", err)
+        return nil, err
+    }
+
+    states := make([]MigrationState, 0, len(migrations))
+    for _, m := range migrations {
+        state := MigrationState{Version: m.Version, Name: m.Name}
+        if row, ok := applied[m.Version]; ok {
+            appliedAt := row.AppliedAt
+            state.Applied = true
+            state.AppliedAt = &appliedAt
+        }
+        states = append(states, state)
+    }
+    return states, nil
+}
+
+// CheckSchema returns ErrSchemaOutOfDate if any migration is pending, or if
+// the database has migrations applied that this binary does not know about.
+func CheckSchema(db *gorm.DB) error {
+    applied, err := appliedMigrations(db)
+    if err != nil {
+        return err
+    }
+
+    known := make(map[int]bool, len(migrations))
+    var pending []int
+    for _, m := range migrations {
+        known[m.Version] = true
+        if _, ok := applied[m.Version]; !ok {
+            pending = append(pending, m.Version)
+        }
+    }
+    if len(pending) > 0 {
+        return fmt.Errorf("%w: pending migrations %v (run \"migrate up\")", ErrSchemaOutOfDate, pending)
+    }
+    for version := range applied {
+        if !known[version] {
+            return fmt.Errorf("%w: database has unknown migration %d, binary is older than the schema", ErrSchemaOutOfDate, version)
+        }
+    }
+    return nil
+}
@@ -2,6 +2,8 @@ package db
 
 import (
     "time"
+
+    "gorm.io/gorm"
 )
 
 // User model represents the users of your application.
@@ -14,6 +16,7 @@ type User struct {
 	State     string    `json:"state"`
     CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
     UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+    DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"` // Set while soft-deleted, until purged
     // Relationship with Transaction model
     Transactions []Transaction `json:"transactions" gorm:"foreignKey:UserID"`
     // Relationship with Session model
@@ -22,12 +25,19 @@ type User struct {
 
 // Transaction model represents a user's transactions.
 type Transaction struct {
-    ID        int     `json:"id" gorm:"primaryKey"`
-    UserID    int     `json:"user_id" gorm:"not null"`  // Foreign key referencing User
-    Amount    float64 `json:"amount" gorm:"not null"`
-    Date      time.Time `json:"date" gorm:"autoCreateTime"`
+    ID          int       `json:"id" gorm:"primaryKey"`
+    UserID      int       `json:"user_id" gorm:"not null;index"`  // Foreign key referencing User
+    Amount      int64     `json:"amount" gorm:"not null;index"` // In minor units of Currency, e.g. cents
+    Currency    string    `json:"currency" gorm:"size:3;not null;default:'USD'"` // ISO 4217 code
+    Category    string    `json:"category,omitempty" gorm:"size:64;index"`
+    Merchant    string    `json:"merchant,omitempty" gorm:"size:128"`
+    Description string    `json:"description,omitempty" gorm:"size:255"`
+    Status      string    `json:"status" gorm:"size:16;not null;default:'posted';index"` // pending, posted or refunded
+    ExternalRef string    `json:"external_ref,omitempty" gorm:"size:128;index"` // The bank's or processor's ID
+    Date        time.Time `json:"date" gorm:"autoCreateTime;index"`
+    DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
     // Foreign key relation back to the User model
-    User      User    `json:"-" gorm:"foreignKey:UserID"`
+    User        User      `json:"-" gorm:"foreignKey:UserID"`
 }
 
 
@@ -37,7 +47,97 @@ type Session struct {
     UserID    int       `json:"user_id" gorm:"not null"`  // Foreign key referencing User
     Token     string    `json:"token" gorm:"not null"`
     ExpiresAt time.Time `json:"expires_at"`
+    DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
     // Foreign key relation back to the User model
     User      User      `json:"-" gorm:"foreignKey:UserID"`
 }
 
+// QueryHistory records a query run by a user against one of their database connections.
+type QueryHistory struct {
+    ID           int       `json:"id" gorm:"primaryKey"`
+    UserID       int       `json:"user_id" gorm:"not null;index"`  // Foreign key referencing User
+    Question     string    `json:"question,omitempty"`              // Natural-language question, if any
+    GeneratedSQL string    `json:"generated_sql,omitempty"`         // SQL produced from the question
+    ExecutedSQL  string    `json:"executed_sql" gorm:"not null"`    // SQL that was actually run
+    ConnectionID string    `json:"connection_id" gorm:"index"`
+    // Stable database identity from ConnectionKey; empty for entries recorded before it was kept
+    ConnectionKey string   `json:"connection_key,omitempty" gorm:"index"`
+    Driver       string    `json:"driver"`
+    DurationMS   int64     `json:"duration_ms"`
+    RowCount     int64     `json:"row_count"`
+    Error        string    `json:"error,omitempty"`
+    // Arguments bound to the placeholders in ExecutedSQL, for parameterized queries
+    Arguments    []interface{} `json:"arguments,omitempty" gorm:"type:text;serializer:json"`
+    CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+    // Foreign key relation back to the User model
+    User         User      `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// SavedQuery is a named, reusable query whose SQL may contain ":name" parameters.
+type SavedQuery struct {
+    ID          int              `json:"id" gorm:"primaryKey"`
+    UserID      int              `json:"user_id" gorm:"not null;index"`  // Foreign key referencing User
+    Name        string           `json:"name" gorm:"not null"`
+    Description string           `json:"description"`
+    SQLQuery    string           `json:"sql_query" gorm:"not null"`
+    Driver      string           `json:"driver,omitempty"`  // Only run on this driver; empty means any
+    Parameters  []QueryParameter `json:"parameters" gorm:"type:text;serializer:json"`
+    CreatedAt   time.Time        `json:"created_at" gorm:"autoCreateTime"`
+    UpdatedAt   time.Time        `json:"updated_at" gorm:"autoUpdateTime"`
+    // Foreign key relation back to the User model
+    User        User             `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// SQLExample is a question and the SQL that correctly answers it on a
+// database, marked good by a user and reused as a few-shot example.
+type SQLExample struct {
+    ID            int       `json:"id" gorm:"primaryKey"`
+    UserID        int       `json:"user_id" gorm:"not null;index"`  // Foreign key referencing User
+    ConnectionKey string    `json:"connection_key" gorm:"not null;index"` // Stable database identity from ConnectionKey
+    Driver        string    `json:"driver"`
+    Question      string    `json:"question" gorm:"not null"`
+    SQLQuery      string    `json:"sql_query" gorm:"not null"`
+    HistoryID     *int      `json:"history_id,omitempty"` // The history entry it was marked from, if any
+    CreatedAt     time.Time `json:"created_at" gorm:"autoCreateTime"`
+    // Foreign key relation back to the User model
+    User          User      `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// SemanticLayer holds the semantic definition for one database.
+type SemanticLayer struct {
+    ID            int                `json:"id" gorm:"primaryKey"`
+    ConnectionKey string             `json:"connection_key" gorm:"not null;uniqueIndex"` // Stable database identity from ConnectionKey
+    Definition    SemanticDefinition `json:"definition" gorm:"type:text;serializer:json"`
+    UpdatedByID   int                `json:"updated_by_id"` // User who last changed the definition
+    CreatedAt     time.Time          `json:"created_at" gorm:"autoCreateTime"`
+    UpdatedAt     time.Time          `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// ConversationTurn is one answered question in a session's conversation
+// with a database, kept as context for follow-up questions.
+type ConversationTurn struct {
+    ID            int             `json:"id" gorm:"primaryKey"`
+    SessionID     int             `json:"session_id" gorm:"not null;index"` // Foreign key referencing Session
+    ConnectionKey string          `json:"connection_key" gorm:"not null"`
+    Question      string          `json:"question" gorm:"not null"`
+    SQLQuery      string          `json:"sql_query" gorm:"not null"`
+    Columns       []ColumnSummary `json:"columns" gorm:"type:text;serializer:json"` // Shape of the result
+    RowCount      int64           `json:"row_count"`
+    CreatedAt     time.Time       `json:"created_at" gorm:"autoCreateTime"`
+    // Foreign key relation back to the Session model
+    Session       Session         `json:"-" gorm:"foreignKey:SessionID"`
+}
+
+// IdempotencyKey records the response to a request made with an
+// Idempotency-Key header, so a retried request gets the same response
+// instead of being applied twice.
+type IdempotencyKey struct {
+    ID          int       `json:"id" gorm:"primaryKey"`
+    Endpoint    string    `json:"endpoint" gorm:"size:64;not null;uniqueIndex:idx_idempotency_keys_endpoint_key"`
+    Key         string    `json:"key" gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_keys_endpoint_key"`
+    RequestHash string    `json:"request_hash" gorm:"not null"` // SHA-256 of the request body
+    StatusCode  int       `json:"status_code"`                  // Zero while the request is in progress
+    ContentType string    `json:"content_type"`
+    Response    string    `json:"response" gorm:"type:text"`
+    CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime;index"` // When the key was claimed
+}
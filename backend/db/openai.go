@@ -0,0 +1,157 @@
+package db
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// System prompts framing SQL generation and explanation requests.
+const (
+    openAISystemPrompt  = "You are a helpful assistant that converts text to SQL queries."
+    openAIExplainPrompt = "You are a helpful data analyst who explains databases to non-technical people."
+)
+
+// OpenAIGenerator generates SQL with an OpenAI-compatible chat-completions API.
+type OpenAIGenerator struct {
+    BaseURL     string // e.g. https://api.openai.com/v1
+    APIKey      string // Optional for local servers
+    Model       string
+    Temperature float64
+    MaxTokens   int
+    Client      *http.Client
+}
+
+// NewOpenAIGeneratorFromEnv configures a generator from OPENAI_BASE_URL,
+// OPENAI_API_KEY, OPENAI_MODEL and OPENAI_TEMPERATURE.
+func NewOpenAIGeneratorFromEnv() *OpenAIGenerator {
+    g := &OpenAIGenerator{
+        BaseURL:   "https://api.openai.com/v1",
+        APIKey:    os.Getenv("OPENAI_API_KEY"),
+        Model:     "gpt-3.5-turbo",
+        MaxTokens: 2000,
+        Client:    http.DefaultClient,
+    }
+    if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
+        g.BaseURL = baseURL
+    }
+    if model := os.Getenv("OPENAI_MODEL"); model != "" {
+        g.Model = model
+    }
+    if value := os.Getenv("OPENAI_TEMPERATURE"); value != "" {
+        if t, err := strconv.ParseFloat(value, 64); err == nil && t >= 0 && t <= 2 {
+            g.Temperature = t
+        }
+    }
+    return g
+}
+
+type chatMessage struct {
+    Role    string `json:"role"`
+    Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+    Model       string        `json:"model"`
+    Messages    []chatMessage `json:"messages"`
+    Temperature float64       `json:"temperature"`
+    MaxTokens   int           `json:"max_tokens,omitempty"`
+}
+
+type chatCompletionResponse struct {
+    Choices []struct {
+        Message chatMessage `json:"message"`
+    } `json:"choices"`
+    Error *struct {
+        Message string `json:"message"`
+    } `json:"error,omitempty"`
+}
+
+// GenerateSQL asks the model for SQL and returns its reply.
+func (g *OpenAIGenerator) GenerateSQL(ctx context.Context, req GenerateRequest) (string, error) {
+    return g.complete(ctx, []chatMessage{
+        {Role: "system", Content: openAISystemPrompt},
+        {Role: "user", Content: BuildPrompt(req)},
+    })
+}
+
+// ExplainSQL asks the model to describe a query in plain English.
+func (g *OpenAIGenerator) ExplainSQL(ctx context.Context, req ExplainSQLRequest) (string, error) {
+    reply, err := g.complete(ctx, []chatMessage{
+        {Role: "system", Content: openAIExplainPrompt},
+        {Role: "user", Content: BuildExplainPrompt(req)},
+    })
+    return strings.TrimSpace(reply), err
+}
+
+// SummarizeResult asks the model for a short narrative of a result set.
+func (g *OpenAIGenerator) SummarizeResult(ctx context.Context, req SummarizeRequest) (string, error) {
+    reply, err := g.complete(ctx, []chatMessage{
+        {Role: "system", Content: openAIExplainPrompt},
+        {Role: "user", Content: BuildSummaryPrompt(req)},
+    })
+    return strings.TrimSpace(reply), err
+}
+
+// complete sends one chat-completions request and returns the first choice.
+func (g *OpenAIGenerator) complete(ctx context.Context, messages []chatMessage) (string, error) {
+    payload, err := json.Marshal(chatCompletionRequest{
+        Model:       g.Model,
+        Messages:    messages,
+        Temperature: g.Temperature,
+        MaxTokens:   g.MaxTokens,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    url := strings.TrimRight(g.BaseURL, "/") + "/chat/completions"
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+    if err != nil {
+        return "", err
+    }
+    httpReq.Header.Set("Content-Type", "application/json")
+    if g.APIKey != "" {
+        httpReq.Header.Set("Authorization", "Bearer "+g.APIKey)
+    }
+
+    client := g.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(httpReq)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if err != nil {
+        return "", err
+    }
+
+    var completion chatCompletionResponse
+    if err := json.Unmarshal(body, &completion); err != nil {
+        if resp.StatusCode != http.StatusOK {
+            return "", fmt.Errorf("chat completion failed: %s", resp.Status)
+        }
+        return "", fmt.Errorf("invalid chat completion response: %v", err)
+    }
+    if completion.Error != nil {
+        return "", fmt.Errorf("chat completion failed: %s", completion.Error.Message)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("chat completion failed: %s", resp.Status)
+    }
+    if len(completion.Choices) == 0 {
+        return "", errors.New("chat completion returned no choices")
+    }
+    return completion.Choices[0].Message.Content, nil
+}
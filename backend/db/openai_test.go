@@ -0,0 +1,162 @@
+package db
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// stubCompletions serves the chat-completions endpoint, recording each
+// request it receives and answering with the given status and body.
+func stubCompletions(t *testing.T, status int, body string) (*httptest.Server, *[]chatCompletionRequest, *[]*http.Request) {
+    t.Helper()
+    var payloads []chatCompletionRequest
+    var requests []*http.Request
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var payload chatCompletionRequest
+        if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+            t.Errorf("decoding request body: %v", err)
+        }
+        payloads = append(payloads, payload)
+        requests = append(requests, r)
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        w.Write([]byte(body))
+    }))
+    t.Cleanup(server.Close)
+    return server, &payloads, &requests
+}
+
+func completionBody(content string) string {
+    body, _ := json.Marshal(map[string]interface{}{
+        "choices": []map[string]interface{}{
+            {"message": map[string]string{"role": "assistant", "content": content}},
+        },
+    })
+    return string(body)
+}
+
+func TestOpenAIGeneratorExtractsFencedSQL(t *testing.T) {
+    reply := "Here is the query:\n\n```sql\nSELECT name FROM users WHERE country = 'NZ';\n```\n\nIt lists users in New Zealand."
+    server, _, requests := stubCompletions(t, http.StatusOK, completionBody(reply))
+
+    g := &OpenAIGenerator{BaseURL: server.URL + "/v1/", APIKey: "secret", Model: "test-model"}
+    output, err := g.GenerateSQL(context.Background(), GenerateRequest{Question: "Who lives in New Zealand?", Dialect: "postgres"})
+    if err != nil {
+        t.Fatalf("GenerateSQL: %v", err)
+    }
+    if output != reply {
+        t.Errorf("GenerateSQL returned %q, want the reply as is", output)
+    }
+
+    sql, err := SanitizeSQL(output, "postgres")
+    if err != nil {
+        t.Fatalf("SanitizeSQL: %v", err)
+    }
+    if want := "SELECT name FROM users WHERE country = 'NZ'"; sql != want {
+        t.Errorf("extracted %q, want %q", sql, want)
+    }
+
+    if len(*requests) != 1 {
+        t.Fatalf("server received %d requests, want 1", len(*requests))
+    }
+    r := (*requests)[0]
+    if r.Method != "POST" || r.URL.Path != "/v1/chat/completions" {
+        t.Errorf("request was %s %s, want POST /v1/chat/completions", r.Method, r.URL.Path)
+    }
+    if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+        t.Errorf("Authorization header is %q", got)
+    }
+}
+
+func TestOpenAIGeneratorStatusError(t *testing.T) {
+    server, _, _ := stubCompletions(t, http.StatusServiceUnavailable, "upstream unavailable")
+
+    g := &OpenAIGenerator{BaseURL: server.URL, Model: "test-model"}
+    _, err := g.GenerateSQL(context.Background(), GenerateRequest{Question: "How many users are there?"})
+    if err == nil {
+        t.Fatal("GenerateSQL succeeded on a 503 reply")
+    }
+    if !strings.Contains(err.Error(), "503") {
+        t.Errorf("error %q doesn't mention the status", err)
+    }
+}
+
+func TestOpenAIGeneratorErrorBody(t *testing.T) {
+    body := `{"error": {"message": "model not found", "type": "invalid_request_error"}}`
+    for _, status := range []int{http.StatusOK, http.StatusNotFound} {
+        server, _, _ := stubCompletions(t, status, body)
+
+        g := &OpenAIGenerator{BaseURL: server.URL, Model: "missing-model"}
+        _, err := g.GenerateSQL(context.Background(), GenerateRequest{Question: "How many users are there?"})
+        if err == nil {
+            t.Fatalf("GenerateSQL succeeded on an error body with status %d", status)
+        }
+        if !strings.Contains(err.Error(), "model not found") {
+            t.Errorf("status %d: error %q doesn't carry the API message", status, err)
+        }
+    }
+}
+
+func TestOpenAIGeneratorNoChoices(t *testing.T) {
+    server, _, _ := stubCompletions(t, http.StatusOK, `{"choices": []}`)
+
+    g := &OpenAIGenerator{BaseURL: server.URL, Model: "test-model"}
+    if _, err := g.GenerateSQL(context.Background(), GenerateRequest{Question: "How many users are there?"}); err == nil {
+        t.Fatal("GenerateSQL succeeded without any choices")
+    }
+}
+
+func TestNewOpenAIGeneratorFromEnv(t *testing.T) {
+    server, payloads, requests := stubCompletions(t, http.StatusOK, completionBody("SELECT 1"))
+    t.Setenv("OPENAI_BASE_URL", server.URL+"/api")
+    t.Setenv("OPENAI_API_KEY", "")
+    t.Setenv("OPENAI_MODEL", "local-llama")
+    t.Setenv("OPENAI_TEMPERATURE", "0.7")
+
+    g := NewOpenAIGeneratorFromEnv()
+    if _, err := g.GenerateSQL(context.Background(), GenerateRequest{Question: "Anything", Dialect: "sqlite"}); err != nil {
+        t.Fatalf("GenerateSQL: %v", err)
+    }
+
+    if len(*payloads) != 1 {
+        t.Fatalf("server received %d requests, want 1", len(*payloads))
+    }
+    if path := (*requests)[0].URL.Path; path != "/api/chat/completions" {
+        t.Errorf("request path is %q, want the base URL from the environment", path)
+    }
+    if got := (*requests)[0].Header.Get("Authorization"); got != "" {
+        t.Errorf("sent Authorization %q without an API key", got)
+    }
+    payload := (*payloads)[0]
+    if payload.Model != "local-llama" {
+        t.Errorf("model is %q, want local-llama", payload.Model)
+    }
+    if payload.Temperature != 0.7 {
+        t.Errorf("temperature is %v, want 0.7", payload.Temperature)
+    }
+    if len(payload.Messages) != 2 || payload.Messages[0].Role != "system" || payload.Messages[1].Role != "user" {
+        t.Fatalf("messages are %+v, want a system and a user message", payload.Messages)
+    }
+    if !strings.Contains(payload.Messages[1].Content, "Anything") {
+        t.Errorf("user message %q doesn't contain the question", payload.Messages[1].Content)
+    }
+}
+
+func TestNewOpenAIGeneratorFromEnvIgnoresBadTemperature(t *testing.T) {
+    t.Setenv("OPENAI_BASE_URL", "")
+    t.Setenv("OPENAI_MODEL", "")
+    for _, value := range []string{"hot", "-1", "2.5"} {
+        t.Setenv("OPENAI_TEMPERATURE", value)
+        g := NewOpenAIGeneratorFromEnv()
+        if g.Temperature != 0 {
+            t.Errorf("OPENAI_TEMPERATURE=%q gave temperature %v, want the default", value, g.Temperature)
+        }
+        if g.BaseURL != "https://api.openai.com/v1" || g.Model != "gpt-3.5-turbo" {
+            t.Errorf("defaults changed to %q, %q", g.BaseURL, g.Model)
+        }
+    }
+}
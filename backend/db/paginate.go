@@ -0,0 +1,242 @@
+package db
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// ErrInvalidCursor is returned when a cursor can't be decoded or belongs to a different query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MaxPageSize caps how many rows a single page may hold.
+const MaxPageSize = 10000
+
+// identifierPattern matches a bare column name usable as an ordering key.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Cursor is the decoded form of the opaque pagination token handed to clients.
+type Cursor struct {
+    Fingerprint string      `json:"f"`           // Identifies the query the cursor belongs to
+    PageSize    int         `json:"n"`
+    OrderKey    string      `json:"k,omitempty"` // Set for keyset pagination
+    After       interface{} `json:"a,omitempty"` // Last key value of the previous page
+    AfterID     int         `json:"i,omitempty"` // Breaks ties on After for listings keyed by ID too
+    Offset      int         `json:"o,omitempty"` // Used when there is no ordering key
+}
+
+// QueryFingerprint identifies a query by its whitespace-normalized SQL,
+// bound arguments and ordering key, so a cursor can't be replayed against
+// a different query.
+func QueryFingerprint(sql string, args []interface{}, orderKey string) string {
+    h := sha256.New()
+    h.Write([]byte(NormalizeSQL(sql)))
+    h.Write([]byte{0})
+    argJSON, _ := json.Marshal(args)
+    h.Write(argJSON)
+    h.Write([]byte{0})
+    h.Write([]byte(orderKey))
+    return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// NormalizeSQL collapses whitespace outside literals and drops comments and
+// trailing semicolons, so trivially different spellings of a query compare equal.
+func NormalizeSQL(sql string) string {
+    var b strings.Builder
+    for _, span := range splitSQLSpans(sql) {
+        switch span.Kind {
+        case spanComment:
+            b.WriteByte(' ')
+        case spanCode:
+            b.WriteString(strings.Join(strings.Fields(span.Text), " "))
+            if strings.TrimRight(span.Text, " \t\r\n") != span.Text {
+                b.WriteByte(' ')
+            }
+        default:
+            b.WriteString(span.Text)
+        }
+    }
+    return strings.TrimRight(strings.TrimSpace(b.String()), "; \t\r\n")
+}
+
+// EncodeCursor serializes a cursor into an opaque URL-safe token.
+func EncodeCursor(c Cursor) string {
+    data, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a token produced by EncodeCursor. Integral key values
+// are decoded as int64 so they bind with the right type.
+func DecodeCursor(token string) (*Cursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+
+    var c Cursor
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    if err := dec.Decode(&c); err != nil || c.PageSize <= 0 || c.Offset < 0 {
+        return nil, ErrInvalidCursor
+    }
+    if n, ok := c.After.(json.Number); ok {
+        if i, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+            c.After = i
+        } else if f, err := n.Float64(); err == nil {
+            c.After = f
+        }
+    }
+    return &c, nil
+}
+
+// Placeholder returns the dialect's native placeholder for the n-th (1-based) argument.
+func Placeholder(dialect string, n int) string {
+    switch dialect {
+    case "postgres":
+        return "$" + strconv.Itoa(n)
+    case "sqlserver":
+        return "@p" + strconv.Itoa(n)
+    }
+    return "?"
+}
+
+// QuoteIdentifier quotes a column or table name for the dialect.
+func QuoteIdentifier(dialect, name string) string {
+    switch dialect {
+    case "mysql":
+        return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+    case "sqlserver":
+        return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+    }
+    return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// PaginateQuery wraps sql so it returns the page described by cursor, plus
+// one extra row that tells the caller whether another page follows.
+//
+// With an ordering key it uses keyset pagination: rows are returned in
+// ascending order of the key, which must be unique and comparable. There is
+// no descending keyset; order_key names a bare column only.
+//
+// Without one it falls back to OFFSET, which needs a stable order to page
+// consistently. A query ending in its own ORDER BY is paged in that order,
+// so it should be total; any other query is ordered by its first column,
+// which is only stable when that column is unique.
+func PaginateQuery(dialect, sql string, args []interface{}, cursor Cursor) (string, []interface{}, error) {
+    inner := strings.TrimRight(strings.TrimSpace(sql), "; \t\r\n")
+    limit := cursor.PageSize + 1
+    args = append([]interface{}{}, args...)
+    clauses := topLevelWords(inner)
+    ordered := clauses["ORDER"]
+    limited := clauses["LIMIT"] || clauses["OFFSET"] || clauses["FETCH"] || clauses["TOP"]
+
+    if cursor.OrderKey != "" {
+        if !identifierPattern.MatchString(cursor.OrderKey) {
+            return "", nil, fmt.Errorf("%w: order key must be a plain column name", ErrInvalidCursor)
+        }
+        key := "q." + QuoteIdentifier(dialect, cursor.OrderKey)
+
+        where := ""
+        if cursor.After != nil {
+            args = append(args, cursor.After)
+            where = fmt.Sprintf(" WHERE %s > %s", key, Placeholder(dialect, len(args)))
+        }
+        if dialect == "sqlserver" {
+            return fmt.Sprintf("SELECT TOP (%d) * FROM (%s) AS q%s ORDER BY %s", limit, derivedTable(inner, ordered, limited), where, key), args, nil
+        }
+        return fmt.Sprintf("SELECT * FROM (%s) AS q%s ORDER BY %s LIMIT %d", inner, where, key, limit), args, nil
+    }
+
+    // The query's own order is kept by paging it in place
+    if ordered && !limited && !clauses["FOR"] {
+        if dialect == "sqlserver" {
+            return fmt.Sprintf("%s OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", inner, cursor.Offset, limit), args, nil
+        }
+        return fmt.Sprintf("%s LIMIT %d OFFSET %d", inner, limit, cursor.Offset), args, nil
+    }
+    if dialect == "sqlserver" {
+        return fmt.Sprintf("SELECT * FROM (%s) AS q ORDER BY 1 OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", derivedTable(inner, ordered, limited), cursor.Offset, limit), args, nil
+    }
+    return fmt.Sprintf("SELECT * FROM (%s) AS q ORDER BY 1 LIMIT %d OFFSET %d", inner, limit, cursor.Offset), args, nil
+}
+
+// derivedTable makes a SQL Server query usable as a derived table, which
+// may only have an ORDER BY alongside TOP, OFFSET or FOR XML.
+func derivedTable(sql string, ordered, limited bool) string {
+    if ordered && !limited {
+        return sql + " OFFSET 0 ROWS"
+    }
+    return sql
+}
+
+// topLevelWords returns the upper-cased words in sql's code outside any
+// parentheses, such as the clauses of the outermost query.
+func topLevelWords(sql string) map[string]bool {
+    words := map[string]bool{}
+    depth := 0
+    for _, span := range splitSQLSpans(sql) {
+        if span.Kind != spanCode {
+            continue
+        }
+        text := span.Text
+        for i := 0; i < len(text); i++ {
+            switch c := text[i]; {
+            case c == '(':
+                depth++
+            case c == ')':
+                depth--
+            case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+                j := i
+                for j < len(text) && (text[j] == '_' || text[j] >= 'A' && text[j] <= 'Z' || text[j] >= 'a' && text[j] <= 'z' || text[j] >= '0' && text[j] <= '9') {
+                    j++
+                }
+                if depth == 0 {
+                    words[strings.ToUpper(text[i:j])] = true
+                }
+                i = j - 1
+            }
+        }
+    }
+    return words
+}
+
+// NextCursor returns the cursor for the page after one that ended with
+// lastRow, or "" when there are no more rows. Keyset cursors need the
+// ordering key in lastRow; the column is matched case-insensitively, since
+// some drivers report names in a different case than the query spelled them.
+// A missing or NULL key fails with ErrInvalidCursor rather than producing a
+// cursor that would restart from the first page.
+func NextCursor(current Cursor, lastRow map[string]interface{}, hasMore bool) (string, error) {
+    if !hasMore {
+        return "", nil
+    }
+    next := current
+    if current.OrderKey != "" {
+        value, ok := lastRow[current.OrderKey]
+        if !ok {
+            for column, v := range lastRow {
+                if strings.EqualFold(column, current.OrderKey) {
+                    value, ok = v, true
+                    break
+                }
+            }
+        }
+        if !ok {
+            return "", fmt.Errorf("%w: order key %q is not a column of the result", ErrInvalidCursor, current.OrderKey)
+        }
+        if value == nil {
+            return "", fmt.Errorf("%w: order key %q is NULL in the last row", ErrInvalidCursor, current.OrderKey)
+        }
+        next.After = value
+    } else {
+        next.Offset += current.PageSize
+    }
+    return EncodeCursor(next), nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestPaginateQuery(t *testing.T) {
+    tests := []struct {
+        name    string
+        dialect string
+        sql     string
+        cursor  Cursor
+        want    string
+    }{
+        {"offset orders by the first column", "postgres", "SELECT * FROM users;", Cursor{PageSize: 10, Offset: 20},
+            "SELECT * FROM (SELECT * FROM users) AS q ORDER BY 1 LIMIT 11 OFFSET 20"},
+        {"offset keeps the query's order", "mysql", "SELECT * FROM users ORDER BY created_at, id", Cursor{PageSize: 10},
+            "SELECT * FROM users ORDER BY created_at, id LIMIT 11 OFFSET 0"},
+        {"window order is not the query's order", "sqlite", "SELECT id, ROW_NUMBER() OVER (ORDER BY id) FROM users", Cursor{PageSize: 5},
+            "SELECT * FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY id) FROM users) AS q ORDER BY 1 LIMIT 6 OFFSET 0"},
+        {"ordered and limited query is wrapped", "postgres", "SELECT * FROM users ORDER BY id LIMIT 50", Cursor{PageSize: 10},
+            "SELECT * FROM (SELECT * FROM users ORDER BY id LIMIT 50) AS q ORDER BY 1 LIMIT 11 OFFSET 0"},
+        {"sqlserver offset", "sqlserver", "SELECT * FROM users", Cursor{PageSize: 10, Offset: 10},
+            "SELECT * FROM (SELECT * FROM users) AS q ORDER BY 1 OFFSET 10 ROWS FETCH NEXT 11 ROWS ONLY"},
+        {"sqlserver offset keeps the query's order", "sqlserver", "SELECT * FROM users ORDER BY name", Cursor{PageSize: 10},
+            "SELECT * FROM users ORDER BY name OFFSET 0 ROWS FETCH NEXT 11 ROWS ONLY"},
+        {"sqlserver top is wrapped as is", "sqlserver", "SELECT TOP 5 * FROM users ORDER BY name", Cursor{PageSize: 10},
+            "SELECT * FROM (SELECT TOP 5 * FROM users ORDER BY name) AS q ORDER BY 1 OFFSET 0 ROWS FETCH NEXT 11 ROWS ONLY"},
+        {"keyset", "postgres", "SELECT * FROM users", Cursor{PageSize: 10, OrderKey: "id", After: int64(7)},
+            `SELECT * FROM (SELECT * FROM users) AS q WHERE q."id" > $1 ORDER BY q."id" LIMIT 11`},
+        {"sqlserver keyset over an ordered query", "sqlserver", "SELECT * FROM users ORDER BY name", Cursor{PageSize: 10, OrderKey: "id"},
+            "SELECT TOP (11) * FROM (SELECT * FROM users ORDER BY name OFFSET 0 ROWS) AS q ORDER BY q.[id]"},
+    }
+    for _, tt := range tests {
+        got, _, err := PaginateQuery(tt.dialect, tt.sql, nil, tt.cursor)
+        if err != nil {
+            t.Errorf("%s: PaginateQuery: %v", tt.name, err)
+            continue
+        }
+        if got != tt.want {
+            t.Errorf("%s: PaginateQuery =\n%s\nwant\n%s", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestPaginateQueryRejectsOrderExpressions(t *testing.T) {
+    for _, key := range []string{"id DESC", "lower(name)", "id; DROP TABLE users"} {
+        _, _, err := PaginateQuery("postgres", "SELECT * FROM users", nil, Cursor{PageSize: 10, OrderKey: key})
+        if !errors.Is(err, ErrInvalidCursor) {
+            t.Errorf("order key %q: error is %v, want ErrInvalidCursor", key, err)
+        }
+    }
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ErrInvalidParameters is wrapped by every parameter declaration or binding error.
+var ErrInvalidParameters = errors.New("invalid query parameters")
+
+// Supported parameter types for saved queries.
+const (
+    ParamString    = "string"
+    ParamInteger   = "integer"
+    ParamNumber    = "number"
+    ParamBoolean   = "boolean"
+    ParamDate      = "date"      // 2006-01-02
+    ParamTimestamp = "timestamp" // RFC 3339
+)
+
+var paramTypes = map[string]bool{
+    ParamString: true, ParamInteger: true, ParamNumber: true,
+    ParamBoolean: true, ParamDate: true, ParamTimestamp: true,
+}
+
+// QueryParameter declares a named parameter (":name" in the SQL) of a saved query.
+type QueryParameter struct {
+    Name     string          `json:"name"`
+    Type     string          `json:"type"`
+    Default  json.RawMessage `json:"default,omitempty"`
+    Required bool            `json:"required,omitempty"`
+}
+
+// namedParamPattern matches ":name" in code spans. The leading group keeps
+// Postgres "::type" casts from being treated as parameters.
+var namedParamPattern = regexp.MustCompile(`(^|[^:]):([A-Za-z_][A-Za-z0-9_]*)`)
+
+// NamedParams returns the distinct ":name" parameters used in sql, in order
+// of first use. Literals, quoted identifiers and comments are ignored.
+func NamedParams(sql string) []string {
+    var names []string
+    seen := make(map[string]bool)
+    for _, span := range splitSQLSpans(sql) {
+        if span.Kind != spanCode {
+            continue
+        }
+        for _, m := range namedParamPattern.FindAllStringSubmatch(span.Text, -1) {
+            if !seen[m[2]] {
+                seen[m[2]] = true
+                names = append(names, m[2])
+            }
+        }
+    }
+    return names
+}
+
+// ValidateParameters checks that declarations are well formed and that they
+// match the parameters used in sql exactly.
+func ValidateParameters(sql string, params []QueryParameter) error {
+    declared := make(map[string]bool, len(params))
+    for _, p := range params {
+        if p.Name == "" {
+            return fmt.Errorf("%w: parameter name is required", ErrInvalidParameters)
+        }
+        if declared[p.Name] {
+            return fmt.Errorf("%w: parameter %q declared twice", ErrInvalidParameters, p.Name)
+        }
+        declared[p.Name] = true
+        if !paramTypes[p.Type] {
+            return fmt.Errorf("%w: parameter %q has unsupported type %q", ErrInvalidParameters, p.Name, p.Type)
+        }
+        if len(p.Default) > 0 {
+            if _, err := convertParam(p, p.Default); err != nil {
+                return fmt.Errorf("%w: default for %q: %v", ErrInvalidParameters, p.Name, err)
+            }
+        }
+    }
+
+    used := NamedParams(sql)
+    for _, name := range used {
+        if !declared[name] {
+            return fmt.Errorf("%w: :%s is used but not declared", ErrInvalidParameters, name)
+        }
+        delete(declared, name)
+    }
+    for name := range declared {
+        return fmt.Errorf("%w: %q is declared but not used", ErrInvalidParameters, name)
+    }
+    return nil
+}
+
+// convertParam decodes a JSON value into the Go type the driver should bind for p.
+func convertParam(p QueryParameter, raw json.RawMessage) (interface{}, error) {
+    if string(raw) == "null" {
+        return nil, fmt.Errorf("parameter %q is null", p.Name)
+    }
+
+    switch p.Type {
+    case ParamString:
+        var v string
+        if err := json.Unmarshal(raw, &v); err != nil {
+            return nil, fmt.Errorf("parameter %q must be a string", p.Name)
+        }
+        return v, nil
+    case ParamInteger:
+        // Decoded generically, since json.Number also accepts quoted numbers
+        var v interface{}
+        dec := json.NewDecoder(bytes.NewReader(raw))
+        dec.UseNumber()
+        if err := dec.Decode(&v); err != nil {
+            return nil, fmt.Errorf("parameter %q must be an integer", p.Name)
+        }
+        number, ok := v.(json.Number)
+        if !ok {
+            return nil, fmt.Errorf("parameter %q must be an integer", p.Name)
+        }
+        n, err := strconv.ParseInt(number.String(), 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("parameter %q must be an integer", p.Name)
+        }
+        return n, nil
+    case ParamNumber:
+        var v float64
+        if err := json.Unmarshal(raw, &v); err != nil {
+            return nil, fmt.Errorf("parameter %q must be a number", p.Name)
+        }
+        return v, nil
+    case ParamBoolean:
+        var v bool
+        if err := json.Unmarshal(raw, &v); err != nil {
+            return nil, fmt.Errorf("parameter %q must be a boolean", p.Name)
+        }
+        return v, nil
+    case ParamDate, ParamTimestamp:
+        var v string
+        if err := json.Unmarshal(raw, &v); err != nil {
+            return nil, fmt.Errorf("parameter %q must be a %s string", p.Name, p.Type)
+        }
+        layout := time.RFC3339
+        if p.Type == ParamDate {
+            layout = "2006-01-02"
+        }
+        t, err := time.Parse(layout, v)
+        if err != nil {
+            return nil, fmt.Errorf("parameter %q must be a %s (%s)", p.Name, p.Type, layout)
+        }
+        return t, nil
+    }
+    return nil, fmt.Errorf("parameter %q has unsupported type %q", p.Name, p.Type)
+}
+
+// BindParameters rewrites the ":name" parameters in sql to the dialect's
+// native placeholders and returns the converted argument list. Values are
+// never interpolated into the SQL text. Missing values fall back to the
+// declared default; a missing required parameter is an error.
+func BindParameters(dialect, sql string, params []QueryParameter, values map[string]json.RawMessage) (string, []interface{}, error) {
+    converted := make(map[string]interface{}, len(params))
+    for _, p := range params {
+        raw, ok := values[p.Name]
+        if !ok || string(raw) == "null" {
+            if len(p.Default) == 0 {
+                if p.Required {
+                    return "", nil, fmt.Errorf("%w: parameter %q is required", ErrInvalidParameters, p.Name)
+                }
+                converted[p.Name] = nil
+                continue
+            }
+            raw = p.Default
+        }
+        v, err := convertParam(p, raw)
+        if err != nil {
+            return "", nil, fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+        }
+        converted[p.Name] = v
+    }
+    for name := range values {
+        if _, ok := converted[name]; !ok {
+            return "", nil, fmt.Errorf("%w: unknown parameter %q", ErrInvalidParameters, name)
+        }
+    }
+
+    var (
+        b        strings.Builder
+        args     []interface{}
+        position = make(map[string]int) // for dialects with numbered placeholders
+        bindErr  error
+    )
+    for _, span := range splitSQLSpans(sql) {
+        if span.Kind != spanCode {
+            b.WriteString(span.Text)
+            continue
+        }
+        b.WriteString(namedParamPattern.ReplaceAllStringFunc(span.Text, func(m string) string {
+            sub := namedParamPattern.FindStringSubmatch(m)
+            prefix, name := sub[1], sub[2]
+            value, ok := converted[name]
+            if !ok {
+                bindErr = fmt.Errorf("%w: :%s is not declared", ErrInvalidParameters, name)
+                return m
+            }
+
+            switch dialect {
+            case "postgres", "sqlserver":
+                n, seen := position[name]
+                if !seen {
+                    args = append(args, value)
+                    n = len(args)
+                    position[name] = n
+                }
+                if dialect == "postgres" {
+                    return prefix + "$" + strconv.Itoa(n)
+                }
+                return prefix + "@p" + strconv.Itoa(n)
+            default:
+                // mysql and sqlite only have positional "?" placeholders
+                args = append(args, value)
+                return prefix + "?"
+            }
+        }))
+    }
+    if bindErr != nil {
+        return "", nil, bindErr
+    }
+    return b.String(), args, nil
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+    "encoding/json"
+    "errors"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestNamedParams(t *testing.T) {
+    tests := []struct {
+        sql  string
+        want []string
+    }{
+        {"SELECT * FROM orders WHERE region = :region AND created_at >= :start_date", []string{"region", "start_date"}},
+        {"SELECT * FROM t WHERE a = :x OR b = :x OR c = :y", []string{"x", "y"}},
+        {"SELECT created_at::date FROM t WHERE id = :id", []string{"id"}},
+        {"SELECT ':not_a_param', \":nor_this\" FROM t -- :or_this\nWHERE a = :a /* :b */", []string{"a"}},
+        {"SELECT 1", nil},
+    }
+    for _, tt := range tests {
+        if got := NamedParams(tt.sql); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("NamedParams(%q) = %v, want %v", tt.sql, got, tt.want)
+        }
+    }
+}
+
+func TestValidateParameters(t *testing.T) {
+    sql := "SELECT * FROM orders WHERE region = :region AND created_at >= :start_date"
+    valid := []QueryParameter{
+        {Name: "region", Type: ParamString, Default: json.RawMessage(`"EMEA"`)},
+        {Name: "start_date", Type: ParamDate, Required: true},
+    }
+    if err := ValidateParameters(sql, valid); err != nil {
+        t.Errorf("ValidateParameters rejected valid declarations: %v", err)
+    }
+
+    tests := []struct {
+        name   string
+        params []QueryParameter
+    }{
+        {"undeclared", []QueryParameter{{Name: "region", Type: ParamString}}},
+        {"unused", append(valid, QueryParameter{Name: "extra", Type: ParamString})},
+        {"duplicate", append(valid, QueryParameter{Name: "region", Type: ParamString})},
+        {"unnamed", append(valid, QueryParameter{Type: ParamString})},
+        {"unknown type", []QueryParameter{{Name: "region", Type: "uuid"}, valid[1]}},
+        {"bad default", []QueryParameter{valid[0], {Name: "start_date", Type: ParamDate, Default: json.RawMessage(`"yesterday"`)}}},
+    }
+    for _, tt := range tests {
+        if err := ValidateParameters(sql, tt.params); !errors.Is(err, ErrInvalidParameters) {
+            t.Errorf("%s: ValidateParameters error is %v, want ErrInvalidParameters", tt.name, err)
+        }
+    }
+}
+
+func TestBindParametersDialects(t *testing.T) {
+    sql := "SELECT * FROM orders WHERE region = :region AND (owner = :owner OR reviewer = :owner) AND note <> ':region'"
+    params := []QueryParameter{
+        {Name: "region", Type: ParamString},
+        {Name: "owner", Type: ParamInteger},
+    }
+    values := map[string]json.RawMessage{
+        "region": json.RawMessage(`"EMEA' OR '1'='1"`),
+        "owner":  json.RawMessage(`42`),
+    }
+
+    tests := []struct {
+        dialect string
+        sql     string
+        args    []interface{}
+    }{
+        {"postgres", "SELECT * FROM orders WHERE region = $1 AND (owner = $2 OR reviewer = $2) AND note <> ':region'",
+            []interface{}{"EMEA' OR '1'='1", int64(42)}},
+        {"sqlserver", "SELECT * FROM orders WHERE region = @p1 AND (owner = @p2 OR reviewer = @p2) AND note <> ':region'",
+            []interface{}{"EMEA' OR '1'='1", int64(42)}},
+        {"mysql", "SELECT * FROM orders WHERE region = ? AND (owner = ? OR reviewer = ?) AND note <> ':region'",
+            []interface{}{"EMEA' OR '1'='1", int64(42), int64(42)}},
+        {"sqlite", "SELECT * FROM orders WHERE region = ? AND (owner = ? OR reviewer = ?) AND note <> ':region'",
+            []interface{}{"EMEA' OR '1'='1", int64(42), int64(42)}},
+    }
+    for _, tt := range tests {
+        gotSQL, gotArgs, err := BindParameters(tt.dialect, sql, params, values)
+        if err != nil {
+            t.Errorf("%s: BindParameters: %v", tt.dialect, err)
+            continue
+        }
+        if gotSQL != tt.sql {
+            t.Errorf("%s: SQL is\n%s\nwant\n%s", tt.dialect, gotSQL, tt.sql)
+        }
+        if !reflect.DeepEqual(gotArgs, tt.args) {
+            t.Errorf("%s: args are %#v, want %#v", tt.dialect, gotArgs, tt.args)
+        }
+    }
+}
+
+func TestBindParametersKeepsPostgresCasts(t *testing.T) {
+    params := []QueryParameter{{Name: "day", Type: ParamDate}}
+    values := map[string]json.RawMessage{"day": json.RawMessage(`"2024-03-01"`)}
+    sql, args, err := BindParameters("postgres", "SELECT created_at::date FROM t WHERE created_at::date = :day::date", params, values)
+    if err != nil {
+        t.Fatalf("BindParameters: %v", err)
+    }
+    if want := "SELECT created_at::date FROM t WHERE created_at::date = $1::date"; sql != want {
+        t.Errorf("SQL is %q, want %q", sql, want)
+    }
+    if want := []interface{}{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}; !reflect.DeepEqual(args, want) {
+        t.Errorf("args are %#v, want %#v", args, want)
+    }
+}
+
+func TestBindParametersValues(t *testing.T) {
+    sql := "SELECT * FROM t WHERE a = :a"
+    tests := []struct {
+        name    string
+        param   QueryParameter
+        value   string // Omitted from the values when empty
+        want    interface{}
+        wantErr bool
+    }{
+        {"string", QueryParameter{Name: "a", Type: ParamString}, `"x"`, "x", false},
+        {"integer", QueryParameter{Name: "a", Type: ParamInteger}, `7`, int64(7), false},
+        {"fractional integer", QueryParameter{Name: "a", Type: ParamInteger}, `7.5`, nil, true},
+        {"integer as string", QueryParameter{Name: "a", Type: ParamInteger}, `"7"`, nil, true},
+        {"number", QueryParameter{Name: "a", Type: ParamNumber}, `7.5`, 7.5, false},
+        {"boolean", QueryParameter{Name: "a", Type: ParamBoolean}, `true`, true, false},
+        {"boolean as string", QueryParameter{Name: "a", Type: ParamBoolean}, `"true"`, nil, true},
+        {"date", QueryParameter{Name: "a", Type: ParamDate}, `"2024-02-29"`, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), false},
+        {"invalid date", QueryParameter{Name: "a", Type: ParamDate}, `"2023-02-29"`, nil, true},
+        {"timestamp", QueryParameter{Name: "a", Type: ParamTimestamp}, `"2024-03-01T12:00:00Z"`, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), false},
+        {"date as timestamp", QueryParameter{Name: "a", Type: ParamTimestamp}, `"2024-03-01"`, nil, true},
+        {"default", QueryParameter{Name: "a", Type: ParamInteger, Default: json.RawMessage(`3`)}, "", int64(3), false},
+        {"null uses default", QueryParameter{Name: "a", Type: ParamInteger, Default: json.RawMessage(`3`)}, `null`, int64(3), false},
+        {"optional without default", QueryParameter{Name: "a", Type: ParamInteger}, "", nil, false},
+        {"required", QueryParameter{Name: "a", Type: ParamInteger, Required: true}, "", nil, true},
+    }
+    for _, tt := range tests {
+        values := map[string]json.RawMessage{}
+        if tt.value != "" {
+            values["a"] = json.RawMessage(tt.value)
+        }
+        _, args, err := BindParameters("postgres", sql, []QueryParameter{tt.param}, values)
+        if tt.wantErr {
+            if !errors.Is(err, ErrInvalidParameters) {
+                t.Errorf("%s: error is %v, want ErrInvalidParameters", tt.name, err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: BindParameters: %v", tt.name, err)
+            continue
+        }
+        if len(args) != 1 || !reflect.DeepEqual(args[0], tt.want) {
+            t.Errorf("%s: args are %#v, want [%#v]", tt.name, args, tt.want)
+        }
+    }
+}
+
+func TestBindParametersRejectsUnknownValues(t *testing.T) {
+    params := []QueryParameter{{Name: "a", Type: ParamString}}
+    values := map[string]json.RawMessage{"a": json.RawMessage(`"x"`), "b": json.RawMessage(`"y"`)}
+    if _, _, err := BindParameters("mysql", "SELECT :a", params, values); !errors.Is(err, ErrInvalidParameters) {
+        t.Errorf("error is %v, want ErrInvalidParameters", err)
+    }
+    if _, _, err := BindParameters("mysql", "SELECT :a, :undeclared", params, nil); !errors.Is(err, ErrInvalidParameters) {
+        t.Errorf("undeclared parameter: error is %v, want ErrInvalidParameters", err)
+    }
+}
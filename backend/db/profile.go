@@ -0,0 +1,353 @@
+package db
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+// ErrTableNotFound is returned when profiling a table that doesn't exist.
+var ErrTableNotFound = errors.New("table not found")
+
+// Column kinds decide which statistics a column gets. Unsupported columns
+// (JSON, binary, XML, arrays) only get null counts, since most databases
+// can't compare or group them.
+const (
+    kindNumber      = "number"
+    kindText        = "text"
+    kindTime        = "time"
+    kindBoolean     = "boolean"
+    kindOther       = "other"
+    kindUnsupported = "unsupported"
+)
+
+// ProfileOptions controls how much work ProfileTable does.
+type ProfileOptions struct {
+    SampleRows    int64 // Tables with more rows are profiled from a sample of about this many
+    TopK          int   // Most frequent values reported per column
+    LengthBuckets int   // Buckets in each string length histogram
+}
+
+// ValueCount is a value and how often it occurs.
+type ValueCount struct {
+    Value interface{} `json:"value"`
+    Count int64       `json:"count"`
+}
+
+// LengthBucket counts string values with lengths in [MinLength, MaxLength].
+type LengthBucket struct {
+    MinLength int64 `json:"min_length"`
+    MaxLength int64 `json:"max_length"`
+    Count     int64 `json:"count"`
+}
+
+// ColumnStats is the data quality report for one column.
+type ColumnStats struct {
+    Name            string         `json:"name"`
+    DatabaseType    string         `json:"database_type"`
+    NullCount       int64          `json:"null_count"`
+    NullRatio       float64        `json:"null_ratio"`
+    Distinct        *int64         `json:"distinct,omitempty"`
+    Min             interface{}    `json:"min,omitempty"`
+    Max             interface{}    `json:"max,omitempty"`
+    TopValues       []ValueCount   `json:"top_values,omitempty"`
+    LengthHistogram []LengthBucket `json:"length_histogram,omitempty"`
+
+    kind                 string
+    minLength, maxLength int64
+}
+
+// TableProfile is the result of ProfileTable. When Sampled is set the
+// statistics describe ProfiledRows sampled rows rather than the whole table.
+type TableProfile struct {
+    Table        string        `json:"table"`
+    Dialect      string        `json:"dialect"`
+    RowCount     int64         `json:"row_count"`
+    Sampled      bool          `json:"sampled"`
+    ProfiledRows int64         `json:"profiled_rows"`
+    Columns      []ColumnStats `json:"columns"`
+}
+
+// numericTypes are the numeric type names of the supported databases,
+// without size or sign modifiers. They are matched exactly, since
+// substrings like "INT" also occur in POINT and INTERVAL.
+var numericTypes = map[string]bool{
+    "INT": true, "INTEGER": true, "TINYINT": true, "SMALLINT": true, "MEDIUMINT": true, "BIGINT": true,
+    "INT2": true, "INT4": true, "INT8": true, "SERIAL": true, "SMALLSERIAL": true, "BIGSERIAL": true,
+    "NUMERIC": true, "DECIMAL": true, "DEC": true, "FLOAT": true, "FLOAT4": true, "FLOAT8": true,
+    "DOUBLE": true, "DOUBLE PRECISION": true, "REAL": true, "MONEY": true, "SMALLMONEY": true,
+}
+
+// geometricTypes are the Postgres and MySQL geometric types, which can't be
+// compared and, on Postgres, can't be grouped or counted distinctly either.
+var geometricTypes = map[string]bool{
+    "POINT": true, "LINE": true, "LSEG": true, "BOX": true, "PATH": true, "POLYGON": true, "CIRCLE": true,
+    "LINESTRING": true, "MULTIPOINT": true, "MULTILINESTRING": true, "MULTIPOLYGON": true, "GEOMETRYCOLLECTION": true,
+}
+
+// columnKind classifies a database type name.
+func columnKind(typeName string) string {
+    t := strings.ToUpper(typeName)
+    contains := func(parts ...string) bool {
+        for _, part := range parts {
+            if strings.Contains(t, part) {
+                return true
+            }
+        }
+        return false
+    }
+    // The bare name, as in DECIMAL for "decimal(10,2)" or INT for "int unsigned"
+    base, _, _ := strings.Cut(t, "(")
+    base = strings.Join(strings.Fields(base), " ")
+    for _, modifier := range []string{"UNSIGNED ", " UNSIGNED", " ZEROFILL", " SIGNED"} {
+        base = strings.Replace(base, modifier, "", 1)
+    }
+
+    switch {
+    case strings.HasPrefix(t, "_") || strings.HasSuffix(t, "[]") || geometricTypes[base] ||
+        contains("JSON", "BLOB", "BYTEA", "BINARY", "IMAGE", "XML", "GEOMETRY", "GEOGRAPHY", "NTEXT"):
+        return kindUnsupported
+    case contains("BOOL") || t == "BIT":
+        return kindBoolean
+    case numericTypes[base]:
+        return kindNumber
+    case contains("DATE", "TIME"):
+        return kindTime
+    case contains("CHAR", "TEXT", "CLOB", "STRING"):
+        return kindText
+    }
+    return kindOther
+}
+
+// ProfileTable computes per-column statistics for a table with aggregate
+// queries: null counts, distinct counts, min/max, the most frequent values
+// and, for strings, a histogram of lengths. Large tables are sampled.
+func ProfileTable(ctx context.Context, db *gorm.DB, table string, opts ProfileOptions) (*TableProfile, error) {
+    dialect := db.Dialector.Name()
+    migrator := db.WithContext(ctx).Migrator()
+    if !migrator.HasTable(table) {
+        return nil, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+    }
+    columnTypes, err := migrator.ColumnTypes(table)
+    if err != nil {
+        return nil, err
+    }
+
+    profile := &TableProfile{Table: table, Dialect: dialect}
+    for _, column := range columnTypes {
+        profile.Columns = append(profile.Columns, ColumnStats{
+            Name:         column.Name(),
+            DatabaseType: strings.ToLower(column.DatabaseTypeName()),
+            kind:         columnKind(column.DatabaseTypeName()),
+        })
+    }
+
+    quoted := quoteTableName(dialect, table)
+    rows, err := queryRowMaps(ctx, db, "SELECT COUNT(*) AS row_count FROM "+quoted)
+    if err != nil {
+        return nil, err
+    }
+    if len(rows) > 0 {
+        profile.RowCount = int64Value(rows[0]["row_count"])
+    }
+
+    source := quoted
+    if opts.SampleRows > 0 && profile.RowCount > opts.SampleRows {
+        profile.Sampled = true
+        source = sampleSource(dialect, quoted, opts.SampleRows, profile.RowCount)
+    }
+    source = "(SELECT * FROM " + source + ") s"
+
+    if err := profileAggregates(ctx, db, dialect, source, profile); err != nil {
+        return nil, err
+    }
+    for i := range profile.Columns {
+        stats := &profile.Columns[i]
+        if stats.kind == kindUnsupported || stats.Distinct == nil || *stats.Distinct == 0 {
+            continue
+        }
+        // Every value of a unique column occurs once, so there's no top
+        if opts.TopK > 0 && *stats.Distinct < profile.ProfiledRows-stats.NullCount {
+            if stats.TopValues, err = topValues(ctx, db, dialect, source, stats.Name, opts.TopK); err != nil {
+                return nil, err
+            }
+        }
+        if stats.kind == kindText && opts.LengthBuckets > 0 {
+            if stats.LengthHistogram, err = lengthHistogram(ctx, db, dialect, source, stats, opts.LengthBuckets); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return profile, nil
+}
+
+// quoteTableName quotes a table name, keeping a schema prefix separate.
+func quoteTableName(dialect, table string) string {
+    parts := strings.Split(table, ".")
+    for i, part := range parts {
+        parts[i] = QuoteIdentifier(dialect, part)
+    }
+    return strings.Join(parts, ".")
+}
+
+// sampleSource returns a FROM clause yielding about sampleRows of total
+// rows. The sample is repeatable, so every query of a profile sees the same
+// rows: Postgres and SQL Server sample pages with a fixed seed, MySQL
+// filters with a seeded RAND and SQLite takes every n-th rowid.
+func sampleSource(dialect, table string, sampleRows, total int64) string {
+    fraction := float64(sampleRows) / float64(total)
+    switch dialect {
+    case "postgres":
+        return fmt.Sprintf("%s TABLESAMPLE SYSTEM (%g) REPEATABLE (42)", table, fraction*100)
+    case "sqlserver":
+        return fmt.Sprintf("%s TABLESAMPLE (%d ROWS) REPEATABLE (42)", table, sampleRows)
+    case "mysql":
+        return fmt.Sprintf("%s WHERE RAND(42) < %g", table, fraction)
+    }
+    return fmt.Sprintf("%s WHERE rowid %% %d = 0", table, int64(math.Ceil(1/fraction)))
+}
+
+// lengthFunction is the dialect's character length function.
+func lengthFunction(dialect string) string {
+    switch dialect {
+    case "sqlserver":
+        return "LEN"
+    case "mysql":
+        return "CHAR_LENGTH"
+    }
+    return "LENGTH"
+}
+
+// profileAggregates fills in the counts and ranges of every column with a
+// single aggregate query over source.
+func profileAggregates(ctx context.Context, db *gorm.DB, dialect, source string, profile *TableProfile) error {
+    selects := []string{"COUNT(*) AS profiled_rows"}
+    for i, stats := range profile.Columns {
+        col := QuoteIdentifier(dialect, stats.Name)
+        selects = append(selects, fmt.Sprintf("COUNT(*) - COUNT(%s) AS c%d_nulls", col, i))
+        if stats.kind == kindUnsupported {
+            continue
+        }
+        selects = append(selects, fmt.Sprintf("COUNT(DISTINCT %s) AS c%d_distinct", col, i))
+        switch stats.kind {
+        case kindText:
+            length := lengthFunction(dialect)
+            selects = append(selects,
+                fmt.Sprintf("MIN(%s(%s)) AS c%d_min_length", length, col, i),
+                fmt.Sprintf("MAX(%s(%s)) AS c%d_max_length", length, col, i))
+            fallthrough
+        case kindNumber, kindTime:
+            selects = append(selects,
+                fmt.Sprintf("MIN(%s) AS c%d_min", col, i),
+                fmt.Sprintf("MAX(%s) AS c%d_max", col, i))
+        }
+    }
+
+    rows, err := queryRowMaps(ctx, db, "SELECT "+strings.Join(selects, ", ")+" FROM "+source)
+    if err != nil || len(rows) == 0 {
+        return err
+    }
+    row := rows[0]
+    profile.ProfiledRows = int64Value(row["profiled_rows"])
+    for i := range profile.Columns {
+        stats := &profile.Columns[i]
+        prefix := "c" + strconv.Itoa(i) + "_"
+        stats.NullCount = int64Value(row[prefix+"nulls"])
+        if profile.ProfiledRows > 0 {
+            stats.NullRatio = float64(stats.NullCount) / float64(profile.ProfiledRows)
+        }
+        if stats.kind == kindUnsupported {
+            continue
+        }
+        distinct := int64Value(row[prefix+"distinct"])
+        stats.Distinct = &distinct
+        stats.Min, stats.Max = row[prefix+"min"], row[prefix+"max"]
+        stats.minLength, stats.maxLength = int64Value(row[prefix+"min_length"]), int64Value(row[prefix+"max_length"])
+    }
+    return nil
+}
+
+// topValues returns a column's k most frequent non-NULL values.
+func topValues(ctx context.Context, db *gorm.DB, dialect, source, column string, k int) ([]ValueCount, error) {
+    col := QuoteIdentifier(dialect, column)
+    query := fmt.Sprintf("SELECT %s AS value, COUNT(*) AS value_count FROM %s WHERE %s IS NOT NULL GROUP BY %s ORDER BY COUNT(*) DESC, %s", col, source, col, col, col)
+    if dialect == "sqlserver" {
+        query = fmt.Sprintf("SELECT TOP (%d) %s", k, strings.TrimPrefix(query, "SELECT "))
+    } else {
+        query += fmt.Sprintf(" LIMIT %d", k)
+    }
+
+    rows, err := queryRowMaps(ctx, db, query)
+    if err != nil {
+        return nil, err
+    }
+    values := make([]ValueCount, len(rows))
+    for i, row := range rows {
+        values[i] = ValueCount{Value: row["value"], Count: int64Value(row["value_count"])}
+    }
+    return values, nil
+}
+
+// lengthHistogram buckets a string column's lengths into equal-width ranges
+// between its shortest and longest value.
+func lengthHistogram(ctx context.Context, db *gorm.DB, dialect, source string, stats *ColumnStats, buckets int) ([]LengthBucket, error) {
+    width := (stats.maxLength - stats.minLength + int64(buckets)) / int64(buckets)
+    if width < 1 {
+        width = 1
+    }
+
+    col := QuoteIdentifier(dialect, stats.Name)
+    bucket := fmt.Sprintf("(%s(%s) - %d) / %d", lengthFunction(dialect), col, stats.minLength, width)
+    if dialect == "mysql" {
+        bucket = fmt.Sprintf("(%s(%s) - %d) DIV %d", lengthFunction(dialect), col, stats.minLength, width)
+    }
+    query := fmt.Sprintf("SELECT %s AS bucket, COUNT(*) AS value_count FROM %s WHERE %s IS NOT NULL GROUP BY %s ORDER BY %s",
+        bucket, source, col, bucket, bucket)
+
+    rows, err := queryRowMaps(ctx, db, query)
+    if err != nil {
+        return nil, err
+    }
+    histogram := make([]LengthBucket, len(rows))
+    for i, row := range rows {
+        low := stats.minLength + int64Value(row["bucket"])*width
+        histogram[i] = LengthBucket{MinLength: low, MaxLength: low + width - 1, Count: int64Value(row["value_count"])}
+    }
+    return histogram, nil
+}
+
+// queryRowMaps runs a query and returns its rows as maps. It goes through
+// ExecuteSQLQuery so a profile that times out is cancelled on the server too,
+// rather than leaving a full-table aggregate running there.
+func queryRowMaps(ctx context.Context, db *gorm.DB, query string) ([]map[string]interface{}, error) {
+    rows, err := ExecuteSQLQuery(ctx, db, query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    return ScanRowMaps(rows.Rows)
+}
+
+// int64Value reads a count from a result value, which drivers return as
+// integers, floats or text.
+func int64Value(value interface{}) int64 {
+    switch v := value.(type) {
+    case int64:
+        return v
+    case int32:
+        return int64(v)
+    case int:
+        return int64(v)
+    case float64:
+        return int64(v)
+    case string:
+        n, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+        return int64(n)
+    }
+    return 0
+}
@@ -0,0 +1,278 @@
+package db
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// PromptExample is a question answered with SQL, shown to the model as a few-shot example.
+type PromptExample struct {
+    Question string `json:"question"`
+    SQL      string `json:"sql"`
+}
+
+// dialectNames are how each driver's database is named in prompts.
+var dialectNames = map[string]string{
+    "postgres":  "PostgreSQL",
+    "mysql":     "MySQL",
+    "sqlite":    "SQLite",
+    "sqlserver": "SQL Server",
+}
+
+// dialectRules steer the model away from syntax the database doesn't accept.
+var dialectRules = map[string][]string{
+    "postgres": {
+        "Limit rows with LIMIT n.",
+        "Use ILIKE for case-insensitive matching.",
+        "Quote identifiers with double quotes.",
+        "Bucket dates with date_trunc('month', column); the current time is NOW().",
+        "Concatenate strings with ||.",
+    },
+    "mysql": {
+        "Limit rows with LIMIT n; there is no TOP.",
+        "There is no ILIKE; use LOWER(column) LIKE LOWER('%text%').",
+        "Quote identifiers with backticks, never double quotes.",
+        "Bucket dates with DATE_FORMAT(column, '%Y-%m-01'); the current time is NOW().",
+        "Concatenate strings with CONCAT(); || is logical OR.",
+        "There is no FULL OUTER JOIN.",
+    },
+    "sqlite": {
+        "Limit rows with LIMIT n; there is no TOP.",
+        "There is no ILIKE; LIKE is already case-insensitive for ASCII.",
+        "Quote identifiers with double quotes.",
+        "Bucket dates with strftime('%Y-%m', column); the current date is date('now').",
+        "Concatenate strings with ||.",
+    },
+    "sqlserver": {
+        "Limit rows with SELECT TOP (n); there is no LIMIT.",
+        "Paginate with OFFSET n ROWS FETCH NEXT m ROWS ONLY, which requires ORDER BY.",
+        "There is no ILIKE; use LIKE, which follows the column collation.",
+        "Quote identifiers with square brackets.",
+        "The current time is GETDATE(); there is no NOW().",
+        "Concatenate strings with + or CONCAT().",
+    },
+}
+
+// dialectExamples show each dialect's idioms for common question shapes.
+var dialectExamples = map[string][]PromptExample{
+    "postgres": {
+        {Question: "What are the 5 most recent orders?", SQL: "SELECT * FROM orders ORDER BY created_at DESC LIMIT 5"},
+        {Question: "How many customers named like smith signed up each month?", SQL: "SELECT date_trunc('month', created_at) AS month, COUNT(*) FROM customers WHERE name ILIKE '%smith%' GROUP BY 1 ORDER BY 1"},
+    },
+    "mysql": {
+        {Question: "What are the 5 most recent orders?", SQL: "SELECT * FROM orders ORDER BY created_at DESC LIMIT 5"},
+        {Question: "How many customers named like smith signed up each month?", SQL: "SELECT DATE_FORMAT(created_at, '%Y-%m-01') AS month, COUNT(*) FROM customers WHERE LOWER(name) LIKE '%smith%' GROUP BY month ORDER BY month"},
+    },
+    "sqlite": {
+        {Question: "What are the 5 most recent orders?", SQL: "SELECT * FROM orders ORDER BY created_at DESC LIMIT 5"},
+        {Question: "How many customers named like smith signed up each month?", SQL: "SELECT strftime('%Y-%m', created_at) AS month, COUNT(*) FROM customers WHERE name LIKE '%smith%' GROUP BY month ORDER BY month"},
+    },
+    "sqlserver": {
+        {Question: "What are the 5 most recent orders?", SQL: "SELECT TOP (5) * FROM orders ORDER BY created_at DESC"},
+        {Question: "How many customers named like smith signed up each month?", SQL: "SELECT DATEFROMPARTS(YEAR(created_at), MONTH(created_at), 1) AS month, COUNT(*) FROM customers WHERE name LIKE '%smith%' GROUP BY DATEFROMPARTS(YEAR(created_at), MONTH(created_at), 1) ORDER BY month"},
+    },
+}
+
+// versionPattern finds the major and minor version in a server version string.
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// parseVersion returns the major and minor version, or zeros when unknown.
+func parseVersion(version string) (int, int) {
+    m := versionPattern.FindStringSubmatch(version)
+    if m == nil {
+        return 0, 0
+    }
+    major, _ := strconv.Atoi(m[1])
+    minor, _ := strconv.Atoi(m[2])
+    return major, minor
+}
+
+// versionRules are rules that depend on the server version. Nothing is
+// added when the version is unknown.
+func versionRules(dialect, version string) []string {
+    major, minor := parseVersion(version)
+    if major == 0 {
+        return nil
+    }
+
+    var rules []string
+    switch dialect {
+    case "mysql":
+        if major < 8 && !strings.Contains(strings.ToLower(version), "mariadb") {
+            rules = append(rules, "This version has no window functions and no WITH (common table expressions); use subqueries.")
+        }
+    case "sqlite":
+        if major == 3 && minor < 39 {
+            rules = append(rules, "This version has no RIGHT JOIN or FULL OUTER JOIN.")
+        }
+        if major == 3 && minor < 25 {
+            rules = append(rules, "This version has no window functions.")
+        }
+    case "sqlserver":
+        // SQL Server 2022 is version 16
+        if major < 16 {
+            rules = append(rules, "This version has no DATETRUNC, GREATEST or LEAST.")
+        }
+        if major < 14 {
+            rules = append(rules, "This version has no STRING_AGG or TRIM.")
+        }
+    }
+    return rules
+}
+
+// BuildPrompt renders a generation request as a single prompt.
+func BuildPrompt(req GenerateRequest) string {
+    var b strings.Builder
+    if name, ok := dialectNames[req.Dialect]; ok {
+        if req.ServerVersion != "" {
+            fmt.Fprintf(&b, "Write a single %s query for %s.\n", name, req.ServerVersion)
+        } else {
+            fmt.Fprintf(&b, "Write a single %s query.\n", name)
+        }
+        rules := append([]string{}, dialectRules[req.Dialect]...)
+        rules = append(rules, versionRules(req.Dialect, req.ServerVersion)...)
+        for _, rule := range rules {
+            fmt.Fprintf(&b, "- %s\n", rule)
+        }
+        b.WriteString("\n")
+    }
+
+    if examples := dialectExamples[req.Dialect]; len(examples) > 0 {
+        b.WriteString("Examples of the syntax to use (their tables are illustrative):\n")
+        writeExamples(&b, examples)
+    }
+
+    if !req.Semantics.Empty() {
+        b.WriteString("Use these business definitions rather than guessing from the schema:\n")
+        writeSemantics(&b, req.Semantics)
+        b.WriteString("\n")
+    }
+    if req.Schema != "" {
+        fmt.Fprintf(&b, "The database has these tables:\n%s\n", req.Schema)
+    }
+    if len(req.Examples) > 0 {
+        b.WriteString("Questions previously answered correctly on this database:\n")
+        writeExamples(&b, req.Examples)
+    }
+    if len(req.Conversation) > 0 {
+        b.WriteString("Earlier questions in this conversation, oldest first:\n")
+        writeConversation(&b, req.Conversation)
+        b.WriteString("If the question follows up on an earlier one, refine the most recent query rather than starting over.\n\n")
+    }
+    fmt.Fprintf(&b, "Question: %s\n", req.Question)
+    for i, attempt := range req.Previous {
+        text := attempt.SQL
+        if text == "" {
+            text = attempt.Output
+        }
+        fmt.Fprintf(&b, "\nAttempt %d:\n%s\nfailed with: %s\n", i+1, text, attempt.Error)
+    }
+    if len(req.Previous) > 0 {
+        b.WriteString("\nFix the query so it runs against the schema above.\n")
+    }
+    b.WriteString("\nReply with only the SQL query.")
+    return b.String()
+}
+
+// writeExamples renders few-shot examples as question and SQL pairs.
+func writeExamples(b *strings.Builder, examples []PromptExample) {
+    for _, example := range examples {
+        fmt.Fprintf(b, "Question: %s\nSQL: %s\n\n", example.Question, example.SQL)
+    }
+}
+
+// writeConversation renders earlier turns with the shape of their results.
+func writeConversation(b *strings.Builder, turns []ConversationTurn) {
+    for _, turn := range turns {
+        fmt.Fprintf(b, "Question: %s\nSQL: %s\n", turn.Question, turn.SQLQuery)
+        columns := make([]string, len(turn.Columns))
+        for i, c := range turn.Columns {
+            columns[i] = c.Name + " (" + c.Type + ")"
+        }
+        fmt.Fprintf(b, "Result: %d rows with columns %s\n\n", turn.RowCount, strings.Join(columns, ", "))
+    }
+}
+
+// writeSemantics renders resolved semantic definitions, one per line.
+func writeSemantics(b *strings.Builder, res *SemanticResolution) {
+    describe := func(description string) string {
+        if description == "" {
+            return ""
+        }
+        return " (" + description + ")"
+    }
+    for _, m := range res.Metrics {
+        fmt.Fprintf(b, "- Metric %q%s: %s", m.Name, describe(m.Description), m.Expression)
+        if m.Filter != "" {
+            fmt.Fprintf(b, " counting only rows where %s", m.Filter)
+        }
+        b.WriteString("\n")
+    }
+    for _, d := range res.Dimensions {
+        fmt.Fprintf(b, "- Dimension %q%s: %s\n", d.Name, describe(d.Description), d.Expression)
+    }
+    for _, f := range res.Filters {
+        fmt.Fprintf(b, "- Filter %q%s: %s\n", f.Name, describe(f.Description), f.Condition)
+    }
+    for _, j := range res.Joins {
+        fmt.Fprintf(b, "- Join %s to %s on %s\n", j.From, j.To, j.On)
+    }
+}
+
+// summaryRowLimit and summaryCharLimit bound how much of a result goes
+// into a summary prompt.
+const (
+    summaryRowLimit  = 50
+    summaryCharLimit = 8000
+)
+
+// BuildExplainPrompt asks for a description of what a query does.
+func BuildExplainPrompt(req ExplainSQLRequest) string {
+    var b strings.Builder
+    b.WriteString("Explain in plain English, for someone who doesn't know SQL, what this query does. ")
+    b.WriteString("Say which tables it reads, how they are joined, which rows it filters, how it groups and sorts, and what each output column means. ")
+    b.WriteString("Keep it to a short paragraph or a few bullet points and don't repeat the SQL.\n\n")
+    if name, ok := dialectNames[req.Dialect]; ok {
+        fmt.Fprintf(&b, "The query is written for %s.\n", name)
+    }
+    fmt.Fprintf(&b, "Query:\n%s\n", req.SQL)
+    return b.String()
+}
+
+// BuildSummaryPrompt asks for a short narrative of a result, including as
+// many rows as fit within the prompt limits.
+func BuildSummaryPrompt(req SummarizeRequest) string {
+    var b strings.Builder
+    b.WriteString("Summarize this query result in two or three sentences for a business reader. ")
+    b.WriteString("Mention the notable values and trends, use only the data shown, and don't describe the SQL.\n\n")
+    if req.Question != "" {
+        fmt.Fprintf(&b, "Question: %s\n", req.Question)
+    }
+    fmt.Fprintf(&b, "Query:\n%s\n\n", req.SQL)
+
+    fmt.Fprintf(&b, "The result has %d rows", len(req.Rows))
+    rows := req.Rows
+    if len(rows) > summaryRowLimit {
+        rows = rows[:summaryRowLimit]
+        fmt.Fprintf(&b, "; the first %d are", summaryRowLimit)
+    }
+    b.WriteString(":\n")
+    written := 0
+    for _, row := range rows {
+        line, err := json.Marshal(row)
+        if err != nil {
+            continue
+        }
+        if written+len(line) > summaryCharLimit {
+            b.WriteString("(remaining rows omitted)\n")
+            break
+        }
+        b.Write(line)
+        b.WriteByte('\n')
+        written += len(line) + 1
+    }
+    return b.String()
+}
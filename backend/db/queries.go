@@ -2,10 +2,13 @@ package db
 
 import (
     "encoding/csv"
+    "errors"
     "io"
     "gorm.io/gorm"
 	"log"
 	"encoding/json"
+	"strings"
+	"time"
 )
 
 
@@ -80,15 +83,59 @@ func UpdateUser(db *gorm.DB, user *User) error {
     return nil
 }
 
-// DeleteUser removes a user and their related data from the database.
+// DeleteUser soft-deletes a user along with their transactions and
+// sessions, stamping them all with the same time so RestoreUser can tell
+// them apart from ones deleted earlier. It returns gorm.ErrRecordNotFound
+// if there is no such user.
 func DeleteUser(db *gorm.DB, userID int) error {
-    if err := db.Delete(&User{}, userID).Error; err != nil {
+    now := time.Now().UTC()
+    err := db.Transaction(func(tx *gorm.DB) error {
+        result := tx.Model(&User{}).Where("id = ?", userID).Update("deleted_at", now)
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            return gorm.ErrRecordNotFound
+        }
+        if err := tx.Model(&Transaction{}).Where("user_id = ?", userID).Update("deleted_at", now).Error; err != nil {
+            return err
+        }
+        return tx.Model(&Session{}).Where("user_id = ?", userID).Update("deleted_at", now).Error
+    })
+    if err != nil {
         log.Println("Error deleting user:", err)
         return err
     }
     return nil
 }
 
+// RestoreUser undoes DeleteUser: the user and the transactions deleted with
+// them come back. Their sessions stay deleted, so they have to sign in again.
+// Restoring a user that isn't deleted does nothing.
+func RestoreUser(db *gorm.DB, userID int) (*User, error) {
+    var user User
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Unscoped().First(&user, userID).Error; err != nil {
+            return err
+        }
+        if !user.DeletedAt.Valid {
+            return nil
+        }
+        deletedAt := user.DeletedAt.Time.UTC()
+        if err := tx.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+            return err
+        }
+        return tx.Unscoped().Model(&Transaction{}).
+            Where("user_id = ? AND deleted_at = ?", userID, deletedAt).
+            Update("deleted_at", nil).Error
+    })
+    if err != nil {
+        log.Println("Error restoring user:", err)
+        return nil, err
+    }
+    return &user, nil
+}
+
 func GetTransactions(dbConn *gorm.DB) ([]Transaction, error) {
 	var transactions []Transaction
 	if err := dbConn.Find(&transactions).Error; err != nil {
@@ -99,6 +146,9 @@ func GetTransactions(dbConn *gorm.DB) ([]Transaction, error) {
 
 // CreateTransaction creates a new transaction for a user.
 func CreateTransaction(db *gorm.DB, transaction *Transaction) error {
+    if err := validateTransaction(transaction); err != nil {
+        return err
+    }
     if err := db.Create(transaction).Error; err != nil {
         log.Println("Error creating transaction:", err)
         return err
@@ -117,6 +167,9 @@ func GetTransaction(db *gorm.DB, transactionID int) (*Transaction, error) {
 
 // UpdateTransaction updates an existing transaction's details.
 func UpdateTransaction(db *gorm.DB, transaction *Transaction) error { // Use *Transaction instead of transaction
+    if err := validateTransaction(transaction); err != nil {
+        return err
+    }
     if err := db.Save(transaction).Error; err != nil {
         log.Println("Error updating transaction:", err)
         return err
@@ -134,10 +187,34 @@ func DeleteTransaction(db *gorm.DB, transactionID int) error { // Use transactio
 }
 
 
+// ErrUserDeleted is returned when restoring a transaction whose user is deleted.
+var ErrUserDeleted = errors.New("the transaction's user is deleted; restore the user first")
+
+// RestoreTransaction brings back a soft-deleted transaction. Restoring one
+// that isn't deleted does nothing.
+func RestoreTransaction(db *gorm.DB, transactionID int) (*Transaction, error) {
+    var transaction Transaction
+    if err := db.Unscoped().Preload("User", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).First(&transaction, transactionID).Error; err != nil {
+        log.Println("Error fetching transaction:", err)
+        return nil, err
+    }
+    if !transaction.DeletedAt.Valid {
+        return &transaction, nil
+    }
+    if transaction.User.DeletedAt.Valid {
+        return nil, ErrUserDeleted
+    }
+    if err := db.Unscoped().Model(&transaction).Update("deleted_at", nil).Error; err != nil {
+        log.Println("Error restoring transaction:", err)
+        return nil, err
+    }
+    return &transaction, nil
+}
+
 // GetUserTransactions retrieves all transactions for a specific user.
 func GetUserTransactions(db *gorm.DB, userID int) ([]Transaction, error) {
     var transactions []Transaction
-    if err := db.Where("user_id = ?", userID).Find(&transactions).Error; err != nil {
+    if err := db.Scopes(TransactionFilter{UserID: userID}.Scope).Find(&transactions).Error; err != nil {
         log.Println("Error fetching transactions:", err)
         return nil, err
     }
@@ -163,6 +240,16 @@ func GetSession(db *gorm.DB, sessionID int) (*Session, error) {
     return &session, nil
 }
 
+// GetSessionByToken retrieves an unexpired session by its token.
+func GetSessionByToken(db *gorm.DB, token string) (*Session, error) {
+    var session Session
+    if err := db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&session).Error; err != nil {
+        log.Println("Error fetching session by token:", err)
+        return nil, err
+    }
+    return &session, nil
+}
+
 // DeleteSession removes a session by its ID.
 func DeleteSession(db *gorm.DB, sessionID int) error {
     if err := db.Delete(&Session{}, sessionID).Error; err != nil {
@@ -202,3 +289,237 @@ func GetUsers(db *gorm.DB) ([]User, error) {
     }
     return users, nil
 }
+
+// HistoryFilter narrows a user's query history listing.
+type HistoryFilter struct {
+    Search        string // Matched against the question and executed SQL
+    ConnectionID  string
+    ConnectionKey string // Stable across reconnects, unlike ConnectionID
+    Limit         int
+    Offset       int
+}
+
+// CreateQueryHistory records a query in the user's history.
+func CreateQueryHistory(db *gorm.DB, entry *QueryHistory) error {
+    if err := db.Create(entry).Error; err != nil {
+        log.Println("Error creating query history:", err)
+        return err
+    }
+    return nil
+}
+
+// GetQueryHistory retrieves a single history entry belonging to a user.
+func GetQueryHistory(db *gorm.DB, userID int, historyID int) (*QueryHistory, error) {
+    var entry QueryHistory
+    if err := db.Where("user_id = ?", userID).First(&entry, historyID).Error; err != nil {
+        log.Println("Error fetching query history:", err)
+        return nil, err
+    }
+    return &entry, nil
+}
+
+// ListQueryHistory retrieves a user's query history, newest first.
+func ListQueryHistory(db *gorm.DB, userID int, filter HistoryFilter) ([]QueryHistory, error) {
+    query := db.Where("user_id = ?", userID)
+    if filter.ConnectionID != "" {
+        query = query.Where("connection_id = ?", filter.ConnectionID)
+    }
+    if filter.ConnectionKey != "" {
+        query = query.Where("connection_key = ?", filter.ConnectionKey)
+    }
+    if filter.Search != "" {
+        pattern := "%" + strings.ToLower(filter.Search) + "%"
+        query = query.Where("LOWER(question) LIKE ? OR LOWER(executed_sql) LIKE ?", pattern, pattern)
+    }
+    if filter.Limit > 0 {
+        query = query.Limit(filter.Limit)
+    }
+    if filter.Offset > 0 {
+        query = query.Offset(filter.Offset)
+    }
+
+    var entries []QueryHistory
+    if err := query.Order("created_at DESC, id DESC").Find(&entries).Error; err != nil {
+        log.Println("Error fetching query history:", err)
+        return nil, err
+    }
+    return entries, nil
+}
+
+// CreateSavedQuery validates and stores a new saved query.
+func CreateSavedQuery(db *gorm.DB, saved *SavedQuery) error {
+    if err := ValidateParameters(saved.SQLQuery, saved.Parameters); err != nil {
+        return err
+    }
+    if err := db.Create(saved).Error; err != nil {
+        log.Println("Error creating saved query:", err)
+        return err
+    }
+    return nil
+}
+
+// GetSavedQuery retrieves a saved query belonging to a user.
+func GetSavedQuery(db *gorm.DB, userID int, savedQueryID int) (*SavedQuery, error) {
+    var saved SavedQuery
+    if err := db.Where("user_id = ?", userID).First(&saved, savedQueryID).Error; err != nil {
+        log.Println("Error fetching saved query:", err)
+        return nil, err
+    }
+    return &saved, nil
+}
+
+// GetSavedQueries retrieves all of a user's saved queries, ordered by name.
+func GetSavedQueries(db *gorm.DB, userID int) ([]SavedQuery, error) {
+    var saved []SavedQuery
+    if err := db.Where("user_id = ?", userID).Order("name").Find(&saved).Error; err != nil {
+        log.Println("Error fetching saved queries:", err)
+        return nil, err
+    }
+    return saved, nil
+}
+
+// UpdateSavedQuery validates and saves changes to a saved query.
+func UpdateSavedQuery(db *gorm.DB, saved *SavedQuery) error {
+    if err := ValidateParameters(saved.SQLQuery, saved.Parameters); err != nil {
+        return err
+    }
+    if err := db.Save(saved).Error; err != nil {
+        log.Println("Error updating saved query:", err)
+        return err
+    }
+    return nil
+}
+
+// DeleteSavedQuery removes a saved query belonging to a user.
+func DeleteSavedQuery(db *gorm.DB, userID int, savedQueryID int) error {
+    if err := db.Where("user_id = ?", userID).Delete(&SavedQuery{}, savedQueryID).Error; err != nil {
+        log.Println("Error deleting saved query:", err)
+        return err
+    }
+    return nil
+}
+
+// CreateSQLExample stores a question and SQL pair marked good by a user.
+func CreateSQLExample(db *gorm.DB, example *SQLExample) error {
+    if err := db.Create(example).Error; err != nil {
+        log.Println("Error creating SQL example:", err)
+        return err
+    }
+    return nil
+}
+
+// GetSQLExamples retrieves a user's examples for a database, newest first.
+// An empty connection key returns examples for every database.
+func GetSQLExamples(db *gorm.DB, userID int, connectionKey string) ([]SQLExample, error) {
+    query := db.Where("user_id = ?", userID)
+    if connectionKey != "" {
+        query = query.Where("connection_key = ?", connectionKey)
+    }
+
+    var examples []SQLExample
+    if err := query.Order("created_at DESC, id DESC").Find(&examples).Error; err != nil {
+        log.Println("Error fetching SQL examples:", err)
+        return nil, err
+    }
+    return examples, nil
+}
+
+// DeleteSQLExample removes an example belonging to a user.
+func DeleteSQLExample(db *gorm.DB, userID int, exampleID int) error {
+    result := db.Where("user_id = ?", userID).Delete(&SQLExample{}, exampleID)
+    if result.Error != nil {
+        log.Println("Error deleting SQL example:", result.Error)
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+// GetSemanticLayer retrieves the semantic layer defined for a database.
+func GetSemanticLayer(db *gorm.DB, connectionKey string) (*SemanticLayer, error) {
+    var layer SemanticLayer
+    if err := db.Where("connection_key = ?", connectionKey).First(&layer).Error; err != nil {
+        return nil, err
+    }
+    return &layer, nil
+}
+
+// SaveSemanticLayer validates a definition and creates or replaces the
+// semantic layer for a database.
+func SaveSemanticLayer(db *gorm.DB, connectionKey string, def SemanticDefinition, userID int) (*SemanticLayer, error) {
+    if err := def.Validate(); err != nil {
+        return nil, err
+    }
+
+    layer, err := GetSemanticLayer(db, connectionKey)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        layer = &SemanticLayer{ConnectionKey: connectionKey}
+    } else if err != nil {
+        log.Println("Error fetching semantic layer:", err)
+        return nil, err
+    }
+    layer.Definition = def
+    layer.UpdatedByID = userID
+
+    if err := db.Save(layer).Error; err != nil {
+        log.Println("Error saving semantic layer:", err)
+        return nil, err
+    }
+    return layer, nil
+}
+
+// DeleteSemanticLayer removes the semantic layer for a database.
+func DeleteSemanticLayer(db *gorm.DB, connectionKey string) error {
+    result := db.Where("connection_key = ?", connectionKey).Delete(&SemanticLayer{})
+    if result.Error != nil {
+        log.Println("Error deleting semantic layer:", result.Error)
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+// CreateConversationTurn records an answered question in a session's conversation.
+func CreateConversationTurn(db *gorm.DB, turn *ConversationTurn) error {
+    if err := db.Create(turn).Error; err != nil {
+        log.Println("Error creating conversation turn:", err)
+        return err
+    }
+    return nil
+}
+
+// GetConversation retrieves the most recent turns of a session's
+// conversation with a database, oldest first.
+func GetConversation(db *gorm.DB, sessionID int, connectionKey string, limit int) ([]ConversationTurn, error) {
+    var turns []ConversationTurn
+    err := db.Where("session_id = ? AND connection_key = ?", sessionID, connectionKey).
+        Order("created_at DESC, id DESC").
+        Limit(limit).
+        Find(&turns).Error
+    if err != nil {
+        log.Println("Error fetching conversation:", err)
+        return nil, err
+    }
+    for i, j := 0, len(turns)-1; i < j; i, j = i+1, j-1 {
+        turns[i], turns[j] = turns[j], turns[i]
+    }
+    return turns, nil
+}
+
+// ClearConversation forgets a session's conversation with a database, or
+// with every database when connectionKey is empty.
+func ClearConversation(db *gorm.DB, sessionID int, connectionKey string) error {
+    query := db.Where("session_id = ?", sessionID)
+    if connectionKey != "" {
+        query = query.Where("connection_key = ?", connectionKey)
+    }
+    if err := query.Delete(&ConversationTurn{}).Error; err != nil {
+        log.Println("Error clearing conversation:", err)
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+    "log"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// PurgeResult counts the rows PurgeDeleted removed for good.
+type PurgeResult struct {
+    Users        int64 `json:"users"`
+    Transactions int64 `json:"transactions"`
+    Sessions     int64 `json:"sessions"`
+}
+
+// PurgeDeleted permanently removes users, transactions and sessions that
+// were soft-deleted before cutoff. A purged user's remaining rows in other
+// tables (history, saved queries, examples) go with them, since nothing
+// could reach those rows afterwards and the foreign keys would block it.
+func PurgeDeleted(db *gorm.DB, cutoff time.Time) (*PurgeResult, error) {
+    result := &PurgeResult{}
+    err := db.Transaction(func(tx *gorm.DB) error {
+        var userIDs []int
+        if err := tx.Unscoped().Model(&User{}).Where("deleted_at < ?", cutoff).Pluck("id", &userIDs).Error; err != nil {
+            return err
+        }
+
+        // Sessions go first, together with their conversations
+        deletedSessions := tx.Unscoped().Model(&Session{}).Select("id").Where("deleted_at < ?", cutoff)
+        if len(userIDs) > 0 {
+            deletedSessions = deletedSessions.Or("user_id IN ?", userIDs)
+        }
+        if err := tx.Where("session_id IN (?)", deletedSessions).Delete(&ConversationTurn{}).Error; err != nil {
+            return err
+        }
+        sessions := tx.Unscoped().Where("deleted_at < ?", cutoff)
+        if len(userIDs) > 0 {
+            sessions = sessions.Or("user_id IN ?", userIDs)
+        }
+        res := sessions.Delete(&Session{})
+        if res.Error != nil {
+            return res.Error
+        }
+        result.Sessions = res.RowsAffected
+
+        transactions := tx.Unscoped().Where("deleted_at < ?", cutoff)
+        if len(userIDs) > 0 {
+            transactions = transactions.Or("user_id IN ?", userIDs)
+        }
+        res = transactions.Delete(&Transaction{})
+        if res.Error != nil {
+            return res.Error
+        }
+        result.Transactions = res.RowsAffected
+
+        if len(userIDs) == 0 {
+            return nil
+        }
+        for _, model := range []interface{}{&QueryHistory{}, &SavedQuery{}, &SQLExample{}} {
+            if err := tx.Where("user_id IN ?", userIDs).Delete(model).Error; err != nil {
+                return err
+            }
+        }
+        res = tx.Unscoped().Where("id IN ?", userIDs).Delete(&User{})
+        if res.Error != nil {
+            return res.Error
+        }
+        result.Users = res.RowsAffected
+        return nil
+    })
+    if err != nil {
+        log.Println("Error purging deleted records:", err)
+        return nil, err
+    }
+    return result, nil
+}
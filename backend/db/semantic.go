@@ -0,0 +1,256 @@
+package db
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "unicode"
+
+    "gopkg.in/yaml.v3"
+)
+
+// ErrInvalidSemanticLayer is returned when a semantic layer definition is malformed.
+var ErrInvalidSemanticLayer = errors.New("invalid semantic layer")
+
+// SemanticDefinition is a connection's business glossary: what terms like
+// "revenue" or "active customers" mean in SQL, and how tables join.
+type SemanticDefinition struct {
+    Metrics    []SemanticMetric    `json:"metrics,omitempty" yaml:"metrics,omitempty"`
+    Dimensions []SemanticDimension `json:"dimensions,omitempty" yaml:"dimensions,omitempty"`
+    Filters    []SemanticFilter    `json:"filters,omitempty" yaml:"filters,omitempty"`
+    Joins      []SemanticJoin      `json:"joins,omitempty" yaml:"joins,omitempty"`
+    // Synonyms maps extra terms to the name of a metric, dimension or filter
+    Synonyms   map[string]string   `json:"synonyms,omitempty" yaml:"synonyms,omitempty"`
+}
+
+// SemanticMetric is an aggregate, such as revenue = SUM(orders.total) over paid orders.
+type SemanticMetric struct {
+    Name        string   `json:"name" yaml:"name"`
+    Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+    Expression  string   `json:"expression" yaml:"expression"`
+    Table       string   `json:"table,omitempty" yaml:"table,omitempty"`
+    Filter      string   `json:"filter,omitempty" yaml:"filter,omitempty"` // Condition the metric always applies
+    Synonyms    []string `json:"synonyms,omitempty" yaml:"synonyms,omitempty"`
+}
+
+// SemanticDimension is something to group or filter by, such as customer country.
+type SemanticDimension struct {
+    Name        string   `json:"name" yaml:"name"`
+    Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+    Expression  string   `json:"expression" yaml:"expression"`
+    Table       string   `json:"table,omitempty" yaml:"table,omitempty"`
+    Synonyms    []string `json:"synonyms,omitempty" yaml:"synonyms,omitempty"`
+}
+
+// SemanticFilter is a named condition, such as active customers.
+type SemanticFilter struct {
+    Name        string   `json:"name" yaml:"name"`
+    Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+    Condition   string   `json:"condition" yaml:"condition"`
+    Table       string   `json:"table,omitempty" yaml:"table,omitempty"`
+    Synonyms    []string `json:"synonyms,omitempty" yaml:"synonyms,omitempty"`
+}
+
+// SemanticJoin is how two tables join.
+type SemanticJoin struct {
+    From string `json:"from" yaml:"from"`
+    To   string `json:"to" yaml:"to"`
+    On   string `json:"on" yaml:"on"`
+}
+
+// SemanticResolution is the part of a semantic layer a question refers to.
+type SemanticResolution struct {
+    Metrics    []SemanticMetric    `json:"metrics,omitempty"`
+    Dimensions []SemanticDimension `json:"dimensions,omitempty"`
+    Filters    []SemanticFilter    `json:"filters,omitempty"`
+    Joins      []SemanticJoin      `json:"joins,omitempty"` // Join path connecting the tables involved
+}
+
+// Empty reports whether the question matched nothing in the layer.
+func (r *SemanticResolution) Empty() bool {
+    return r == nil || len(r.Metrics) == 0 && len(r.Dimensions) == 0 && len(r.Filters) == 0
+}
+
+// ParseSemanticDefinition reads a definition from YAML or JSON, which is a
+// subset of YAML, and validates it. Unknown fields are rejected.
+func ParseSemanticDefinition(data []byte) (*SemanticDefinition, error) {
+    var def SemanticDefinition
+    dec := yaml.NewDecoder(bytes.NewReader(data))
+    dec.KnownFields(true)
+    if err := dec.Decode(&def); err != nil && !errors.Is(err, io.EOF) {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidSemanticLayer, err)
+    }
+    if err := def.Validate(); err != nil {
+        return nil, err
+    }
+    return &def, nil
+}
+
+// Validate checks that every entry is complete, names are unique and
+// synonyms point at defined names.
+func (d *SemanticDefinition) Validate() error {
+    names := make(map[string]bool)
+    define := func(kind, name, sql string) error {
+        key := semanticKey(name)
+        switch {
+        case key == "":
+            return fmt.Errorf("%w: %s without a name", ErrInvalidSemanticLayer, kind)
+        case strings.TrimSpace(sql) == "":
+            return fmt.Errorf("%w: %s %q has no SQL", ErrInvalidSemanticLayer, kind, name)
+        case names[key]:
+            return fmt.Errorf("%w: %q is defined more than once", ErrInvalidSemanticLayer, name)
+        }
+        names[key] = true
+        return nil
+    }
+
+    for _, m := range d.Metrics {
+        if err := define("metric", m.Name, m.Expression); err != nil {
+            return err
+        }
+    }
+    for _, dim := range d.Dimensions {
+        if err := define("dimension", dim.Name, dim.Expression); err != nil {
+            return err
+        }
+    }
+    for _, f := range d.Filters {
+        if err := define("filter", f.Name, f.Condition); err != nil {
+            return err
+        }
+    }
+    for _, j := range d.Joins {
+        if j.From == "" || j.To == "" || strings.TrimSpace(j.On) == "" {
+            return fmt.Errorf("%w: joins need from, to and on", ErrInvalidSemanticLayer)
+        }
+    }
+    for term, target := range d.Synonyms {
+        if !names[semanticKey(target)] {
+            return fmt.Errorf("%w: synonym %q refers to undefined %q", ErrInvalidSemanticLayer, term, target)
+        }
+    }
+    return nil
+}
+
+// semanticKey normalizes a term for matching: lower-case words with simple
+// plurals reduced, so "Active Customers" matches "active customer".
+func semanticKey(term string) string {
+    words := strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+    for i, word := range words {
+        if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+            words[i] = strings.TrimSuffix(word, "s")
+        }
+    }
+    return strings.Join(words, " ")
+}
+
+// containsTerm reports whether the normalized question contains the term
+// as whole words.
+func containsTerm(question, term string) bool {
+    key := semanticKey(term)
+    return key != "" && strings.Contains(" "+question+" ", " "+key+" ")
+}
+
+// Resolve finds the metrics, dimensions and filters a question mentions by
+// name or synonym, and the joins needed to connect their tables.
+func (d *SemanticDefinition) Resolve(question string) *SemanticResolution {
+    q := semanticKey(question)
+
+    // Terms that refer to each name, including the name itself
+    terms := make(map[string][]string)
+    for term, target := range d.Synonyms {
+        terms[semanticKey(target)] = append(terms[semanticKey(target)], term)
+    }
+    mentioned := func(name string, synonyms []string) bool {
+        for _, term := range append(append([]string{name}, synonyms...), terms[semanticKey(name)]...) {
+            if containsTerm(q, term) {
+                return true
+            }
+        }
+        return false
+    }
+
+    res := &SemanticResolution{}
+    var tables []string
+    for _, m := range d.Metrics {
+        if mentioned(m.Name, m.Synonyms) {
+            res.Metrics = append(res.Metrics, m)
+            tables = append(tables, m.Table)
+        }
+    }
+    for _, dim := range d.Dimensions {
+        if mentioned(dim.Name, dim.Synonyms) {
+            res.Dimensions = append(res.Dimensions, dim)
+            tables = append(tables, dim.Table)
+        }
+    }
+    for _, f := range d.Filters {
+        if mentioned(f.Name, f.Synonyms) {
+            res.Filters = append(res.Filters, f)
+            tables = append(tables, f.Table)
+        }
+    }
+    res.Joins = d.joinPath(tables)
+    return res
+}
+
+// joinPath returns the joins connecting every table to the first one,
+// following the shortest path through the declared joins.
+func (d *SemanticDefinition) joinPath(tables []string) []SemanticJoin {
+    var root string
+    for _, t := range tables {
+        if t != "" {
+            root = t
+            break
+        }
+    }
+    if root == "" {
+        return nil
+    }
+
+    // Breadth-first search from the root over joins in either direction
+    via := map[string]int{root: -1}
+    queue := []string{root}
+    for len(queue) > 0 {
+        table := queue[0]
+        queue = queue[1:]
+        for i, j := range d.Joins {
+            next := ""
+            switch table {
+            case j.From:
+                next = j.To
+            case j.To:
+                next = j.From
+            }
+            if _, seen := via[next]; next != "" && !seen {
+                via[next] = i
+                queue = append(queue, next)
+            }
+        }
+    }
+
+    used := make(map[int]bool)
+    var path []SemanticJoin
+    for _, t := range tables {
+        for t != "" && t != root {
+            i, ok := via[t]
+            if !ok {
+                break // Not connected; the generator has to work it out from the schema
+            }
+            if !used[i] {
+                used[i] = true
+                path = append(path, d.Joins[i])
+            }
+            if d.Joins[i].From == t {
+                t = d.Joins[i].To
+            } else {
+                t = d.Joins[i].From
+            }
+        }
+    }
+    return path
+}
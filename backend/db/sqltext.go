@@ -0,0 +1,117 @@
+package db
+
+import (
+    "strings"
+)
+
+// sqlSpanKind classifies a run of SQL text.
+type sqlSpanKind int
+
+const (
+    spanCode    sqlSpanKind = iota // Plain SQL outside any literal or comment
+    spanString                     // '...' or a Postgres $tag$...$tag$ literal
+    spanIdent                      // "...", `...` or [...] quoted identifier
+    spanComment                    // -- line or /* block */ comment
+)
+
+// sqlSpan is a contiguous run of SQL text of one kind.
+type sqlSpan struct {
+    Kind sqlSpanKind
+    Text string
+}
+
+// splitSQLSpans breaks SQL into code, literal, quoted identifier and comment
+// spans so callers can rewrite or inspect only the code parts. It is a lexer,
+// not a parser, and is deliberately lenient: an unterminated literal or
+// comment runs to the end of the input.
+func splitSQLSpans(sql string) []sqlSpan {
+    var spans []sqlSpan
+    start := 0
+    emit := func(kind sqlSpanKind, end int) {
+        if end > start {
+            spans = append(spans, sqlSpan{Kind: kind, Text: sql[start:end]})
+        }
+        start = end
+    }
+
+    for i := 0; i < len(sql); {
+        c := sql[i]
+        switch {
+        case c == '\'':
+            emit(spanCode, i)
+            i = scanQuoted(sql, i, '\'')
+            emit(spanString, i)
+        case c == '"' || c == '`':
+            emit(spanCode, i)
+            i = scanQuoted(sql, i, c)
+            emit(spanIdent, i)
+        case c == '[':
+            emit(spanCode, i)
+            i = scanQuoted(sql, i, ']')
+            emit(spanIdent, i)
+        case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+            emit(spanCode, i)
+            if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+                i += end
+            } else {
+                i = len(sql)
+            }
+            emit(spanComment, i)
+        case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+            emit(spanCode, i)
+            if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+                i += end + 4
+            } else {
+                i = len(sql)
+            }
+            emit(spanComment, i)
+        case c == '$':
+            if tag, ok := dollarQuoteTag(sql[i:]); ok {
+                emit(spanCode, i)
+                if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+                    i += len(tag) + end + len(tag)
+                } else {
+                    i = len(sql)
+                }
+                emit(spanString, i)
+                continue
+            }
+            i++
+        default:
+            i++
+        }
+    }
+    emit(spanCode, len(sql))
+    return spans
+}
+
+// scanQuoted returns the index just past the literal starting at sql[start],
+// which closes with the byte close. A doubled closing byte is an escape.
+func scanQuoted(sql string, start int, close byte) int {
+    for i := start + 1; i < len(sql); i++ {
+        if sql[i] != close {
+            continue
+        }
+        if i+1 < len(sql) && sql[i+1] == close {
+            i++
+            continue
+        }
+        return i + 1
+    }
+    return len(sql)
+}
+
+// dollarQuoteTag reports whether s starts with a Postgres dollar-quote
+// opener such as $$ or $body$, returning the tag.
+func dollarQuoteTag(s string) (string, bool) {
+    for i := 1; i < len(s); i++ {
+        c := s[i]
+        if c == '$' {
+            return s[:i+1], true
+        }
+        if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+            return "", false
+        }
+    }
+    return "", false
+}
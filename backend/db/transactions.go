@@ -0,0 +1,438 @@
+package db
+
+import (
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Transaction errors.
+var (
+    ErrInvalidTransaction = errors.New("invalid transaction")         // A transaction failed validation
+    ErrInvalidListing     = errors.New("invalid transaction listing") // Unknown sort field
+    ErrImportRejected     = errors.New("import rejected")             // Some imported rows are invalid
+)
+
+// Transaction statuses.
+const (
+    StatusPending  = "pending"
+    StatusPosted   = "posted"
+    StatusRefunded = "refunded"
+)
+
+// DefaultCurrency is used for transactions created without a currency.
+const DefaultCurrency = "USD"
+
+// Page sizes for transaction listings.
+const (
+    DefaultTransactionPageSize = 50
+    MaxTransactionPageSize     = 500
+)
+
+// transactionSortFields are the indexed columns listings can be sorted by.
+var transactionSortFields = map[string]bool{
+    "id": true, "date": true, "amount": true, "user_id": true, "category": true, "status": true,
+}
+
+// Column sizes of the transaction text fields.
+const (
+    maxCategoryLength    = 64
+    maxMerchantLength    = 128
+    maxDescriptionLength = 255
+    maxExternalRefLength = 128
+)
+
+// currencyExponents maps the active ISO 4217 currency codes to the number of
+// decimal places in their minor unit. Codes without a minor unit, such as
+// precious metals (XAU) and testing codes (XTS), are left out.
+var currencyExponents = map[string]int{
+    "AED": 2, "AFN": 2, "ALL": 2, "AMD": 2, "ANG": 2, "AOA": 2, "ARS": 2, "AUD": 2, "AWG": 2, "AZN": 2,
+    "BAM": 2, "BBD": 2, "BDT": 2, "BGN": 2, "BHD": 3, "BIF": 0, "BMD": 2, "BND": 2, "BOB": 2, "BOV": 2,
+    "BRL": 2, "BSD": 2, "BTN": 2, "BWP": 2, "BYN": 2, "BZD": 2,
+    "CAD": 2, "CDF": 2, "CHE": 2, "CHF": 2, "CHW": 2, "CLF": 4, "CLP": 0, "CNY": 2, "COP": 2, "COU": 2,
+    "CRC": 2, "CUP": 2, "CVE": 2, "CZK": 2,
+    "DJF": 0, "DKK": 2, "DOP": 2, "DZD": 2,
+    "EGP": 2, "ERN": 2, "ETB": 2, "EUR": 2,
+    "FJD": 2, "FKP": 2,
+    "GBP": 2, "GEL": 2, "GHS": 2, "GIP": 2, "GMD": 2, "GNF": 0, "GTQ": 2, "GYD": 2,
+    "HKD": 2, "HNL": 2, "HTG": 2, "HUF": 2,
+    "IDR": 2, "ILS": 2, "INR": 2, "IQD": 3, "IRR": 2, "ISK": 0,
+    "JMD": 2, "JOD": 3, "JPY": 0,
+    "KES": 2, "KGS": 2, "KHR": 2, "KMF": 0, "KPW": 2, "KRW": 0, "KWD": 3, "KYD": 2, "KZT": 2,
+    "LAK": 2, "LBP": 2, "LKR": 2, "LRD": 2, "LSL": 2, "LYD": 3,
+    "MAD": 2, "MDL": 2, "MGA": 2, "MKD": 2, "MMK": 2, "MNT": 2, "MOP": 2, "MRU": 2, "MUR": 2, "MVR": 2,
+    "MWK": 2, "MXN": 2, "MXV": 2, "MYR": 2, "MZN": 2,
+    "NAD": 2, "NGN": 2, "NIO": 2, "NOK": 2, "NPR": 2, "NZD": 2,
+    "OMR": 3,
+    "PAB": 2, "PEN": 2, "PGK": 2, "PHP": 2, "PKR": 2, "PLN": 2, "PYG": 0,
+    "QAR": 2,
+    "RON": 2, "RSD": 2, "RUB": 2, "RWF": 0,
+    "SAR": 2, "SBD": 2, "SCR": 2, "SDG": 2, "SEK": 2, "SGD": 2, "SHP": 2, "SLE": 2, "SOS": 2, "SRD": 2,
+    "SSP": 2, "STN": 2, "SVC": 2, "SYP": 2, "SZL": 2,
+    "THB": 2, "TJS": 2, "TMT": 2, "TND": 3, "TOP": 2, "TRY": 2, "TTD": 2, "TWD": 2, "TZS": 2,
+    "UAH": 2, "UGX": 0, "USD": 2, "USN": 2, "UYI": 0, "UYU": 2, "UYW": 4, "UZS": 2,
+    "VED": 2, "VES": 2, "VND": 0, "VUV": 0,
+    "WST": 2,
+    "XAF": 0, "XCD": 2, "XCG": 2, "XOF": 0, "XPF": 0,
+    "YER": 2,
+    "ZAR": 2, "ZMW": 2, "ZWG": 2,
+}
+
+// CurrencyExponent is the number of decimal places in a currency's minor
+// unit, e.g. 2 for USD (cents) and 0 for JPY.
+func CurrencyExponent(currency string) int {
+    if exponent, ok := currencyExponents[currency]; ok {
+        return exponent
+    }
+    return 2
+}
+
+// validCurrency reports whether code is an active ISO 4217 currency code.
+func validCurrency(code string) bool {
+    _, ok := currencyExponents[code]
+    return ok
+}
+
+// validateTransaction normalizes a transaction, filling in the default
+// currency and status, and checks its fields.
+func validateTransaction(t *Transaction) error {
+    t.Currency = strings.ToUpper(strings.TrimSpace(t.Currency))
+    if t.Currency == "" {
+        t.Currency = DefaultCurrency
+    }
+    t.Status = strings.ToLower(strings.TrimSpace(t.Status))
+    if t.Status == "" {
+        t.Status = StatusPosted
+    }
+    t.Category = strings.TrimSpace(t.Category)
+    t.Merchant = strings.TrimSpace(t.Merchant)
+    t.Description = strings.TrimSpace(t.Description)
+    t.ExternalRef = strings.TrimSpace(t.ExternalRef)
+
+    switch {
+    case t.UserID <= 0:
+        return fmt.Errorf("%w: user_id is required", ErrInvalidTransaction)
+    case !validCurrency(t.Currency):
+        return fmt.Errorf("%w: currency %q is not an ISO 4217 code", ErrInvalidTransaction, t.Currency)
+    case t.Status != StatusPending && t.Status != StatusPosted && t.Status != StatusRefunded:
+        return fmt.Errorf("%w: status must be pending, posted or refunded", ErrInvalidTransaction)
+    case len(t.Category) > maxCategoryLength:
+        return fmt.Errorf("%w: category is longer than %d characters", ErrInvalidTransaction, maxCategoryLength)
+    case len(t.Merchant) > maxMerchantLength:
+        return fmt.Errorf("%w: merchant is longer than %d characters", ErrInvalidTransaction, maxMerchantLength)
+    case len(t.Description) > maxDescriptionLength:
+        return fmt.Errorf("%w: description is longer than %d characters", ErrInvalidTransaction, maxDescriptionLength)
+    case len(t.ExternalRef) > maxExternalRefLength:
+        return fmt.Errorf("%w: external_ref is longer than %d characters", ErrInvalidTransaction, maxExternalRefLength)
+    }
+    return nil
+}
+
+// TransactionFilter selects transactions. Zero fields don't filter; From is
+// inclusive and To exclusive, and amounts are in minor units.
+type TransactionFilter struct {
+    UserID    int        `json:"user_id,omitempty"`
+    From      *time.Time `json:"from,omitempty"`
+    To        *time.Time `json:"to,omitempty"`
+    MinAmount *int64     `json:"min_amount,omitempty"`
+    MaxAmount *int64     `json:"max_amount,omitempty"`
+    Category  string     `json:"category,omitempty"`
+    Status    string     `json:"status,omitempty"`
+    Currency  string     `json:"currency,omitempty"`
+}
+
+// Scope adds the filter's conditions to a query.
+func (f TransactionFilter) Scope(db *gorm.DB) *gorm.DB {
+    if f.UserID != 0 {
+        db = db.Where("user_id = ?", f.UserID)
+    }
+    if f.From != nil {
+        db = db.Where("date >= ?", f.From.UTC())
+    }
+    if f.To != nil {
+        db = db.Where("date < ?", f.To.UTC())
+    }
+    if f.MinAmount != nil {
+        db = db.Where("amount >= ?", *f.MinAmount)
+    }
+    if f.MaxAmount != nil {
+        db = db.Where("amount <= ?", *f.MaxAmount)
+    }
+    if f.Category != "" {
+        db = db.Where("category = ?", f.Category)
+    }
+    if f.Status != "" {
+        db = db.Where("status = ?", f.Status)
+    }
+    if f.Currency != "" {
+        db = db.Where("currency = ?", f.Currency)
+    }
+    return db
+}
+
+// TransactionPage selects one page of a listing. Sort defaults to id.
+type TransactionPage struct {
+    Sort   string
+    Desc   bool
+    Limit  int
+    Cursor string // next_cursor from the previous page
+}
+
+// ListTransactions returns a page of the transactions matching filter and
+// the cursor of the next page, or "" after the last one. Pages are keyed on
+// the sort column with the ID breaking ties, so rows aren't skipped or
+// repeated when transactions are added between requests.
+func ListTransactions(db *gorm.DB, filter TransactionFilter, page TransactionPage) ([]Transaction, string, error) {
+    if page.Sort == "" {
+        page.Sort = "id"
+    }
+    if !transactionSortFields[page.Sort] {
+        return nil, "", fmt.Errorf("%w: cannot sort by %q", ErrInvalidListing, page.Sort)
+    }
+    if page.Limit <= 0 {
+        page.Limit = DefaultTransactionPageSize
+    }
+    if page.Limit > MaxTransactionPageSize {
+        page.Limit = MaxTransactionPageSize
+    }
+
+    order := page.Sort
+    if page.Desc {
+        order += " desc"
+    }
+    fingerprint := QueryFingerprint("transactions", []interface{}{filter}, order)
+
+    query := db.Scopes(filter.Scope)
+    if page.Cursor != "" {
+        cursor, err := DecodeCursor(page.Cursor)
+        if err != nil {
+            return nil, "", err
+        }
+        if cursor.Fingerprint != fingerprint {
+            return nil, "", fmt.Errorf("%w: cursor belongs to a different listing", ErrInvalidCursor)
+        }
+
+        after := cursor.After
+        if page.Sort == "date" {
+            text, _ := after.(string)
+            t, err := time.Parse(time.RFC3339Nano, text)
+            if err != nil {
+                return nil, "", ErrInvalidCursor
+            }
+            after = t
+        }
+        op := ">"
+        if page.Desc {
+            op = "<"
+        }
+        if page.Sort == "id" {
+            query = query.Where("id "+op+" ?", after)
+        } else {
+            query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", page.Sort, op, page.Sort, op), after, after, cursor.AfterID)
+        }
+    }
+
+    if page.Sort != "id" {
+        order += ", id"
+        if page.Desc {
+            order += " desc"
+        }
+    }
+
+    // One extra row tells whether another page follows
+    var transactions []Transaction
+    if err := query.Order(order).Limit(page.Limit + 1).Find(&transactions).Error; err != nil {
+        log.Println("Error listing transactions:", err)
+        return nil, "", err
+    }
+    if len(transactions) <= page.Limit {
+        return transactions, "", nil
+    }
+
+    transactions = transactions[:page.Limit]
+    last := transactions[len(transactions)-1]
+    next := Cursor{Fingerprint: fingerprint, PageSize: page.Limit, OrderKey: page.Sort, AfterID: last.ID}
+    switch page.Sort {
+    case "id":
+        next.After = last.ID
+    case "date":
+        next.After = last.Date.Format(time.RFC3339Nano)
+    case "amount":
+        next.After = last.Amount
+    case "user_id":
+        next.After = last.UserID
+    case "category":
+        next.After = last.Category
+    case "status":
+        next.After = last.Status
+    }
+    return transactions, EncodeCursor(next), nil
+}
+
+// ParseAmount converts a decimal amount in major units, such as "-12.34",
+// into minor units of currency without going through floating point.
+func ParseAmount(text, currency string) (int64, error) {
+    text = strings.TrimSpace(text)
+    number := text
+    negative := false
+    // At most one sign; any further sign fails the digit check below
+    if strings.HasPrefix(number, "-") || strings.HasPrefix(number, "+") {
+        negative = number[0] == '-'
+        number = number[1:]
+    }
+
+    whole, fraction, _ := strings.Cut(number, ".")
+    exponent := CurrencyExponent(currency)
+    if len(fraction) > exponent {
+        return 0, fmt.Errorf("%w: amount %q has more than %d decimal places for %s", ErrInvalidTransaction, text, exponent, currency)
+    }
+    digits := whole + fraction + strings.Repeat("0", exponent-len(fraction))
+    if whole == "" || strings.Trim(digits, "0123456789") != "" {
+        return 0, fmt.Errorf("%w: amount %q is not a decimal number", ErrInvalidTransaction, text)
+    }
+    amount, err := strconv.ParseInt(digits, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("%w: amount %q is out of range", ErrInvalidTransaction, text)
+    }
+    if negative {
+        amount = -amount
+    }
+    return amount, nil
+}
+
+// ImportError describes an invalid row of an import. Rows are numbered
+// from 1, not counting a CSV header.
+type ImportError struct {
+    Row   int    `json:"row"`
+    Error string `json:"error"`
+}
+
+// ParseTransactionCSV reads transactions from CSV with a header row naming
+// the columns: user_id and amount are required, and currency, category,
+// merchant, description, status, external_ref and date are optional.
+// Amounts are decimals in major units, like bank exports, and dates are
+// RFC 3339 or YYYY-MM-DD. Rows that can't be parsed are reported rather
+// than skipped.
+func ParseTransactionCSV(r io.Reader) ([]Transaction, []ImportError, error) {
+    reader := csv.NewReader(r)
+    reader.TrimLeadingSpace = true
+    header, err := reader.Read()
+    if err != nil {
+        return nil, nil, fmt.Errorf("%w: missing CSV header: %v", ErrImportRejected, err)
+    }
+    columns := make(map[string]int, len(header))
+    for i, name := range header {
+        columns[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+    for _, required := range []string{"user_id", "amount"} {
+        if _, ok := columns[required]; !ok {
+            return nil, nil, fmt.Errorf("%w: CSV has no %s column", ErrImportRejected, required)
+        }
+    }
+
+    var transactions []Transaction
+    var problems []ImportError
+    for row := 1; ; row++ {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            problems = append(problems, ImportError{Row: row, Error: err.Error()})
+            continue
+        }
+        field := func(name string) string {
+            if i, ok := columns[name]; ok && i < len(record) {
+                return strings.TrimSpace(record[i])
+            }
+            return ""
+        }
+
+        t := Transaction{
+            Currency:    strings.ToUpper(field("currency")),
+            Category:    field("category"),
+            Merchant:    field("merchant"),
+            Description: field("description"),
+            Status:      field("status"),
+            ExternalRef: field("external_ref"),
+        }
+        if t.Currency == "" {
+            t.Currency = DefaultCurrency
+        }
+        if t.UserID, err = strconv.Atoi(field("user_id")); err != nil {
+            problems = append(problems, ImportError{Row: row, Error: "user_id must be an integer"})
+            continue
+        }
+        if t.Amount, err = ParseAmount(field("amount"), t.Currency); err != nil {
+            problems = append(problems, ImportError{Row: row, Error: err.Error()})
+            continue
+        }
+        if date := field("date"); date != "" {
+            if t.Date, err = time.Parse(time.RFC3339, date); err != nil {
+                if t.Date, err = time.Parse("2006-01-02", date); err != nil {
+                    problems = append(problems, ImportError{Row: row, Error: "date must be YYYY-MM-DD or RFC 3339"})
+                    continue
+                }
+            }
+        }
+        transactions = append(transactions, t)
+    }
+    return transactions, problems, nil
+}
+
+// ImportTransactions validates every transaction, including that its user
+// exists, and inserts them all in one database transaction. If any is
+// invalid nothing is inserted, and the problems are returned along with
+// ErrImportRejected.
+func ImportTransactions(db *gorm.DB, transactions []Transaction) ([]ImportError, error) {
+    var problems []ImportError
+    userIDs := make(map[int]bool)
+    for i := range transactions {
+        if err := validateTransaction(&transactions[i]); err != nil {
+            problems = append(problems, ImportError{Row: i + 1, Error: err.Error()})
+            continue
+        }
+        userIDs[transactions[i].UserID] = false
+    }
+
+    ids := make([]int, 0, len(userIDs))
+    for id := range userIDs {
+        ids = append(ids, id)
+    }
+    var existing []int
+    if len(ids) > 0 {
+        if err := db.Model(&User{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+            log.Println("Error checking import users:", err)
+            return nil, err
+        }
+    }
+    for _, id := range existing {
+        userIDs[id] = true
+    }
+    for i, t := range transactions {
+        if found, checked := userIDs[t.UserID]; checked && !found {
+            problems = append(problems, ImportError{Row: i + 1, Error: fmt.Sprintf("user %d does not exist", t.UserID)})
+        }
+    }
+    if len(problems) > 0 {
+        sort.Slice(problems, func(i, j int) bool { return problems[i].Row < problems[j].Row })
+        return problems, ErrImportRejected
+    }
+
+    err := db.Transaction(func(tx *gorm.DB) error {
+        return tx.CreateInBatches(transactions, 500).Error
+    })
+    if err != nil {
+        log.Println("Error importing transactions:", err)
+        return nil, err
+    }
+    return nil, nil
+}
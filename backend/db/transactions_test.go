@@ -0,0 +1,150 @@
+package db
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseAmount(t *testing.T) {
+    tests := []struct {
+        text     string
+        currency string
+        want     int64
+        wantErr  bool
+    }{
+        {"12.34", "USD", 1234, false},
+        {"12", "USD", 1200, false},
+        {"12.3", "USD", 1230, false},
+        {"12.", "USD", 1200, false},
+        {"0.05", "EUR", 5, false},
+        {"  7.50 ", "GBP", 750, false},
+        {"-12.34", "USD", -1234, false},
+        {"+12.34", "USD", 1234, false},
+        {"-0.01", "USD", -1, false},
+
+        // Signs
+        {"--5", "USD", 0, true},
+        {"-+5", "USD", 0, true},
+        {"+-5", "USD", 0, true},
+        {"++5", "USD", 0, true},
+        {"5-", "USD", 0, true},
+        {"-", "USD", 0, true},
+
+        // Decimal places follow the currency's minor unit
+        {"12.345", "USD", 0, true},
+        {"1500", "JPY", 1500, false},
+        {"1500.", "JPY", 1500, false},
+        {"1500.5", "JPY", 0, true},
+        {"-1500", "JPY", -1500, false},
+        {"1.234", "KWD", 1234, false},
+        {"1.2", "KWD", 1200, false},
+        {"1.2345", "KWD", 0, true},
+        {"0.0001", "CLF", 1, false},
+
+        // Not numbers
+        {"", "USD", 0, true},
+        {".50", "USD", 0, true},
+        {"1,234.56", "USD", 0, true},
+        {"1.2.3", "KWD", 0, true},
+        {"12a", "USD", 0, true},
+        {"1e3", "USD", 0, true},
+        {"$12", "USD", 0, true},
+        {"99999999999999999999", "USD", 0, true},
+    }
+    for _, tt := range tests {
+        got, err := ParseAmount(tt.text, tt.currency)
+        if tt.wantErr {
+            if err == nil {
+                t.Errorf("ParseAmount(%q, %s) = %d, want an error", tt.text, tt.currency, got)
+            } else if !errors.Is(err, ErrInvalidTransaction) {
+                t.Errorf("ParseAmount(%q, %s) error %v doesn't wrap ErrInvalidTransaction", tt.text, tt.currency, err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("ParseAmount(%q, %s) failed: %v", tt.text, tt.currency, err)
+        } else if got != tt.want {
+            t.Errorf("ParseAmount(%q, %s) = %d, want %d", tt.text, tt.currency, got, tt.want)
+        }
+    }
+}
+
+func TestParseTransactionCSV(t *testing.T) {
+    input := strings.Join([]string{
+        "User_ID, Amount, Currency, Category, Merchant, Description, Status, External_Ref, Date",
+        "1, 12.34, usd, groceries, Corner Shop, Weekly shop, posted, ref-1, 2024-03-01",
+        "2, -1500, JPY, travel, , , pending, , 2024-03-02T09:30:00+09:00",
+        "3, 1.234, KWD, , , , , , ",
+        "4, --5, USD, , , , , , ",
+        "5, 1500.5, JPY, , , , , , ",
+        "6, 1.2345, KWD, , , , , , ",
+        "x, 1.00, USD, , , , , , ",
+        "7, 1.00, USD, , , , , , 01/03/2024",
+        "8, 1.00, USD, , , , , , 2024-02-30",
+        "9, 1.00",
+    }, "\n")
+
+    transactions, problems, err := ParseTransactionCSV(strings.NewReader(input))
+    if err != nil {
+        t.Fatalf("ParseTransactionCSV: %v", err)
+    }
+
+    if len(transactions) != 3 {
+        t.Fatalf("parsed %d transactions, want 3: %+v", len(transactions), transactions)
+    }
+    first := transactions[0]
+    if first.UserID != 1 || first.Amount != 1234 || first.Currency != "USD" || first.Category != "groceries" ||
+        first.Merchant != "Corner Shop" || first.Description != "Weekly shop" || first.Status != "posted" || first.ExternalRef != "ref-1" {
+        t.Errorf("first row parsed as %+v", first)
+    }
+    if want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC); !first.Date.Equal(want) {
+        t.Errorf("first row date is %v, want %v", first.Date, want)
+    }
+    if second := transactions[1]; second.Amount != -1500 || second.Currency != "JPY" || !second.Date.Equal(time.Date(2024, 3, 2, 0, 30, 0, 0, time.UTC)) {
+        t.Errorf("second row parsed as %+v", second)
+    }
+    if third := transactions[2]; third.Amount != 1234 || third.Currency != "KWD" || !third.Date.IsZero() {
+        t.Errorf("third row parsed as %+v", third)
+    }
+
+    wantProblems := map[int]string{
+        4:  "not a decimal number",
+        5:  "more than 0 decimal places",
+        6:  "more than 3 decimal places",
+        7:  "user_id must be an integer",
+        8:  "date must be",
+        9:  "date must be",
+        10: "wrong number of fields",
+    }
+    if len(problems) != len(wantProblems) {
+        t.Errorf("got %d problems, want %d: %+v", len(problems), len(wantProblems), problems)
+    }
+    for _, problem := range problems {
+        want, ok := wantProblems[problem.Row]
+        if !ok {
+            t.Errorf("unexpected problem on row %d: %s", problem.Row, problem.Error)
+        } else if !strings.Contains(problem.Error, want) {
+            t.Errorf("row %d problem is %q, want it to mention %q", problem.Row, problem.Error, want)
+        }
+    }
+}
+
+func TestParseTransactionCSVDefaultsCurrency(t *testing.T) {
+    transactions, problems, err := ParseTransactionCSV(strings.NewReader("amount,user_id\n5.5,1\n"))
+    if err != nil || len(problems) > 0 {
+        t.Fatalf("ParseTransactionCSV: %v %+v", err, problems)
+    }
+    if len(transactions) != 1 || transactions[0].Currency != DefaultCurrency || transactions[0].Amount != 550 {
+        t.Errorf("parsed %+v, want 550 minor units of %s", transactions, DefaultCurrency)
+    }
+}
+
+func TestParseTransactionCSVRejectsMissingColumns(t *testing.T) {
+    for _, input := range []string{"", "user_id,currency\n1,USD\n", "amount\n1.00\n"} {
+        if _, _, err := ParseTransactionCSV(strings.NewReader(input)); !errors.Is(err, ErrImportRejected) {
+            t.Errorf("ParseTransactionCSV(%q) error is %v, want ErrImportRejected", input, err)
+        }
+    }
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "log"
     "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "syscall"
+    "time"
     "backend/routes"
     "backend/db"
     "github.com/gorilla/mux"
     "github.com/rs/cors"
+    "gorm.io/gorm"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish on SIGINT/SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
 // Add the debug function here, at package level
 func debugRoutes(router *mux.Router) {
     router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
@@ -28,6 +40,48 @@ func loggingMiddleware(next http.Handler) http.Handler {
     })
 }
 
+// runMigrate handles the "migrate up|down|status" subcommand.
+func runMigrate(dbConn *gorm.DB, args []string) error {
+    if len(args) == 0 {
+        return fmt.Errorf("usage: migrate up|down [steps]|status")
+    }
+
+    switch args[0] {
+    case "up":
+        if err := db.MigrateUp(dbConn); err != nil {
+            return err
+        }
+        log.Println("Database schema is up to date")
+    case "down":
+        steps := 1
+        if len(args) > 1 {
+            n, err := strconv.Atoi(args[1])
+            if err != nil || n < 1 {
+                return fmt.Errorf("invalid step count: %s", args[1])
+            }
+            steps = n
+        }
+        if err := db.MigrateDown(dbConn, steps); err != nil {
+            return err
+        }
+    case "status":
+        states, err := db.MigrationStatus(dbConn)
+        if err != nil {
+            return err
+        }
+        for _, state := range states {
+            applied := "pending"
+            if state.Applied {
+                applied = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+            }
+            fmt.Printf("%4d  %-30s %s\n", state.Version, state.Name, applied)
+        }
+    default:
+        return fmt.Errorf("unknown migrate command: %s", args[0])
+    }
+    return nil
+}
+
 func main() {
     // Setup CockroachDB connection if needed (for static uses)
     dbConn, err := db.ConnectDB()
@@ -35,6 +89,18 @@ func main() {
         log.Fatal("Failed to connect to CockroachDB:", err)
     }
 
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        if err := runMigrate(dbConn, os.Args[2:]); err != nil {
+            log.Fatal("Migration failed:", err)
+        }
+        return
+    }
+
+    // Refuse to serve against a schema that doesn't match this binary
+    if err := db.CheckSchema(dbConn); err != nil {
+        log.Fatal("Schema check failed: ", err)
+    }
+
     // Initialize the router
     router := mux.NewRouter()
 	
@@ -45,6 +111,9 @@ func main() {
     // Setup routes
     routes.SetupRoutes(router, dbConn)
 
+    // Purge soft-deleted records once they are past the retention window
+    routes.StartPurgeJob(dbConn)
+
     // Add debug call here, after routes are set up
     log.Println("Registered routes:")
     debugRoutes(router)
@@ -57,7 +126,48 @@ func main() {
     })
     handler := c.Handler(router)
 
+    server := &http.Server{
+        Addr:              ":8080",
+        Handler:           handler,
+        ReadHeaderTimeout: 5 * time.Second,
+        ReadTimeout:       15 * time.Second, // /transactions/bulk extends its own
+        WriteTimeout:      routes.WriteTimeout(), // follows QUERY_MAX_TIMEOUT_MS
+        IdleTimeout:       2 * time.Minute,
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
     // Start the HTTP server
-    log.Println("Server starting on port 8080")
-    log.Fatal(http.ListenAndServe(":8080", handler))
+    serverErr := make(chan error, 1)
+    go func() {
+        log.Println("Server starting on port 8080")
+        serverErr <- server.ListenAndServe()
+    }()
+
+    select {
+    case err := <-serverErr:
+        if !errors.Is(err, http.ErrServerClosed) {
+            log.Fatal("Server failed:", err)
+        }
+    case <-ctx.Done():
+        log.Println("Shutdown signal received, draining requests")
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+        defer cancel()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            log.Println("Error draining requests:", err)
+        }
+    }
+
+    // Stop background jobs, then close every user database and the
+    // application database before exiting
+    routes.StopJobs()
+    routes.StopPurgeJob()
+    routes.CloseUserConnections()
+    if sqlDB, err := dbConn.DB(); err == nil {
+        if err := sqlDB.Close(); err != nil {
+            log.Println("Error closing application database:", err)
+        }
+    }
+    log.Println("Server stopped")
 }
\ No newline at end of file
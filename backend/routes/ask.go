@@ -0,0 +1,299 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "time"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Text-to-SQL settings, overridable through the environment.
+var (
+    textToSQLMaxAttempts  = envInt("TEXT_TO_SQL_MAX_ATTEMPTS", 3)
+    textToSQLContextTurns = envInt("TEXT_TO_SQL_CONTEXT_TURNS", 5) // Earlier questions kept as context
+)
+
+// textToSQL is the generator used by /database/ask.
+var textToSQL db.Generator = db.NewOpenAIGeneratorFromEnv()
+
+// AskRequest is the body of POST /database/ask.
+type AskRequest struct {
+    Question     string `json:"question"`
+    ConnectionID string `json:"connection_id,omitempty"`
+    MaxAttempts  int    `json:"max_attempts,omitempty"`
+    TimeoutMS    int    `json:"timeout_ms,omitempty"`
+    DryRun       bool   `json:"dry_run,omitempty"`   // Generate and validate without executing
+    Summarize    bool   `json:"summarize,omitempty"` // Add a narrative summary of the result
+    // Forget the session's earlier questions on this connection before asking
+    NewConversation bool `json:"new_conversation,omitempty"`
+}
+
+// ExplainSQLRequest is the body of POST /database/explain-sql.
+type ExplainSQLRequest struct {
+    SQLQuery     string `json:"sql_query"`
+    ConnectionID string `json:"connection_id,omitempty"` // Optional; tells the model the dialect
+}
+
+// ExplainSQLResponse is a plain-English description of a query.
+type ExplainSQLResponse struct {
+    SQLQuery    string `json:"sql_query"`
+    Explanation string `json:"explanation"`
+}
+
+// AskResponse carries the generated SQL, every generation attempt and,
+// unless the request was a dry run, the query result.
+type AskResponse struct {
+    Question  string                   `json:"question"`
+    SQLQuery  string                   `json:"sql_query,omitempty"`
+    Examples  []db.PromptExample       `json:"examples,omitempty"`  // Past examples included in the prompt
+    Semantics *db.SemanticResolution   `json:"semantics,omitempty"` // Business definitions the question matched
+    Context   int                      `json:"context_turns,omitempty"` // Earlier questions given as context
+    Attempts  []db.GenerationAttempt   `json:"attempts"`
+    Result    []map[string]interface{} `json:"result,omitempty"`
+    Chart     *db.ChartSpec            `json:"chart,omitempty"`   // Suggested visualization of the result
+    Summary   string                   `json:"summary,omitempty"` // Narrative of the result, when requested
+    Error     string                   `json:"error,omitempty"`
+}
+
+// writeAskResponse writes an AskResponse with the given status.
+func writeAskResponse(w http.ResponseWriter, status int, response AskResponse) {
+    if response.Attempts == nil {
+        response.Attempts = []db.GenerationAttempt{}
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(response)
+}
+
+// setupAskRoutes defines the routes for answering natural-language questions
+// and explaining SQL.
+func setupAskRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // Route to turn a question into SQL, repairing failed attempts, and run it
+    router.HandleFunc("/database/ask", func(w http.ResponseWriter, r *http.Request) {
+        var req AskRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid input", http.StatusBadRequest)
+            return
+        }
+        if req.Question == "" {
+            http.Error(w, "Question cannot be empty", http.StatusBadRequest)
+            return
+        }
+        if req.TimeoutMS < 0 || req.MaxAttempts < 0 {
+            http.Error(w, "timeout_ms and max_attempts cannot be negative", http.StatusBadRequest)
+            return
+        }
+        maxAttempts := textToSQLMaxAttempts
+        if req.MaxAttempts > 0 && req.MaxAttempts < maxAttempts {
+            maxAttempts = req.MaxAttempts
+        }
+
+        uc := lookupConnection(req.ConnectionID)
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return
+        }
+
+        ctx, cancel := queryContext(r, req.TimeoutMS)
+        defer cancel()
+
+        // Follow-up questions build on the session's conversation with this database
+        var conversation []db.ConversationTurn
+        session, hasSession := requestSession(dbConn, r)
+        if hasSession {
+            if req.NewConversation {
+                if err := db.ClearConversation(dbConn, session.ID, uc.Key); err != nil {
+                    http.Error(w, "Failed to reset conversation", http.StatusInternalServerError)
+                    return
+                }
+            } else if turns, err := db.GetConversation(dbConn, session.ID, uc.Key, textToSQLContextTurns); err == nil {
+                conversation = turns
+            }
+        }
+
+        response := AskResponse{
+            Question:  req.Question,
+            Examples:  similarExamples(dbConn, r, uc, req.Question),
+            Semantics: resolveSemantics(dbConn, uc, req.Question),
+            Context:   len(conversation),
+        }
+        sqlQuery, attempts, err := db.GenerateValidSQL(ctx, textToSQL, uc.DB, db.GenerateRequest{
+            Question:      req.Question,
+            Dialect:       uc.Driver,
+            ServerVersion: uc.ServerVersion,
+            Examples:      response.Examples,
+            Semantics:     response.Semantics,
+            Conversation:  conversation,
+        }, maxAttempts)
+        response.Attempts = attempts
+        if err != nil {
+            response.Error = err.Error()
+            switch {
+            case errors.Is(err, db.ErrGenerationFailed):
+                writeAskResponse(w, http.StatusUnprocessableEntity, response)
+            case ctx.Err() != nil:
+                status, message, ok := queryErrorStatus(ctx, err)
+                if !ok {
+                    return
+                }
+                response.Error = message
+                writeAskResponse(w, status, response)
+            default:
+                log.Println("Error generating SQL:", err)
+                writeAskResponse(w, http.StatusBadGateway, response)
+            }
+            return
+        }
+        response.SQLQuery = sqlQuery
+
+        if req.DryRun {
+            writeAskResponse(w, http.StatusOK, response)
+            return
+        }
+        if !checkQueryCost(w, r, uc, req.TimeoutMS, sqlQuery, nil) {
+            return
+        }
+
+        entry := newHistoryEntry(r, dbConn, QueryRequest{
+            SQLQuery:     sqlQuery,
+            Question:     req.Question,
+            GeneratedSQL: sqlQuery,
+        }, uc)
+
+        ctx, running := trackQuery(ctx, sqlQuery)
+        defer running.done()
+        w.Header().Set("X-Query-ID", running.ID)
+
+        // Generated SQL is checked to be a query, but it runs read-only regardless
+        columns, result, err := collectRows(ctx, db.ExecuteReadOnlyQuery, uc.DB, running, sqlQuery, nil)
+        response.Result = result
+        recordHistory(dbConn, entry, time.Since(running.StartedAt), running.rows.Load(), err)
+        if err != nil {
+            status, message, ok := queryErrorStatus(ctx, err)
+            if !ok {
+                log.Println("Client went away, SQL query cancelled")
+                return
+            }
+            log.Println("Failed to execute generated SQL query:", err)
+            response.Error = message
+            writeAskResponse(w, status, response)
+            return
+        }
+
+        response.Chart = db.RecommendChart(columns, result)
+
+        if hasSession {
+            db.CreateConversationTurn(dbConn, &db.ConversationTurn{
+                SessionID:     session.ID,
+                ConnectionKey: uc.Key,
+                Question:      req.Question,
+                SQLQuery:      sqlQuery,
+                Columns:       db.SummarizeColumns(columns, result),
+                RowCount:      int64(len(result)),
+            })
+        }
+
+        // A failed summary doesn't fail the question; the rows are still returned
+        if req.Summarize {
+            response.Summary, err = textToSQL.SummarizeResult(ctx, db.SummarizeRequest{
+                Question: req.Question,
+                SQL:      sqlQuery,
+                Rows:     response.Result,
+            })
+            if err != nil {
+                log.Println("Error summarizing query result:", err)
+            }
+        }
+
+        writeAskResponse(w, http.StatusOK, response)
+    }).Methods("POST")
+
+    // Route to describe what a query does in plain English
+    router.HandleFunc("/database/explain-sql", func(w http.ResponseWriter, r *http.Request) {
+        var req ExplainSQLRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid input", http.StatusBadRequest)
+            return
+        }
+        if req.SQLQuery == "" {
+            http.Error(w, "SQL query cannot be empty", http.StatusBadRequest)
+            return
+        }
+
+        explainReq := db.ExplainSQLRequest{SQL: req.SQLQuery}
+        if uc := lookupConnection(req.ConnectionID); uc != nil {
+            explainReq.Dialect = uc.Driver
+        } else if req.ConnectionID != "" {
+            http.Error(w, "Connection not found", http.StatusNotFound)
+            return
+        }
+
+        ctx, cancel := queryContext(r, 0)
+        defer cancel()
+        explanation, err := textToSQL.ExplainSQL(ctx, explainReq)
+        if err != nil {
+            log.Println("Error explaining SQL:", err)
+            http.Error(w, "Failed to explain SQL: "+err.Error(), http.StatusBadGateway)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(ExplainSQLResponse{
+            SQLQuery:    req.SQLQuery,
+            Explanation: explanation,
+        })
+    }).Methods("POST")
+
+    // Route to show the session's conversation with a connection
+    router.HandleFunc("/conversation", func(w http.ResponseWriter, r *http.Request) {
+        session, ok := requestSession(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+        uc := lookupConnection(r.URL.Query().Get("connection_id"))
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return
+        }
+
+        turns, err := db.GetConversation(dbConn, session.ID, uc.Key, textToSQLContextTurns)
+        if err != nil {
+            http.Error(w, "Failed to retrieve conversation", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(turns)
+    }).Methods("GET")
+
+    // Route to forget the session's conversation, with one connection or all of them
+    router.HandleFunc("/conversation", func(w http.ResponseWriter, r *http.Request) {
+        session, ok := requestSession(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        var connectionKey string
+        if connectionID := r.URL.Query().Get("connection_id"); connectionID != "" {
+            uc := lookupConnection(connectionID)
+            if uc == nil {
+                http.Error(w, "Connection not found", http.StatusNotFound)
+                return
+            }
+            connectionKey = uc.Key
+        }
+
+        if err := db.ClearConversation(dbConn, session.ID, connectionKey); err != nil {
+            http.Error(w, "Failed to reset conversation", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }).Methods("DELETE")
+}
@@ -0,0 +1,159 @@
+package routes
+
+import (
+    "container/list"
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+)
+
+// Result cache settings, overridable through the environment.
+var (
+    cacheMaxBytes      = envInt("QUERY_CACHE_MAX_BYTES", 64<<20)
+    cacheMaxEntryBytes = envInt("QUERY_CACHE_MAX_ENTRY_BYTES", 4<<20)
+    cacheMaxTTL        = envMilliseconds("QUERY_CACHE_MAX_TTL_MS", time.Hour)
+)
+
+// cacheEntry is a cached, already-encoded QueryResponse.
+type cacheEntry struct {
+    key          string
+    connectionID string
+    body         []byte
+    rowCount     int64
+    expiresAt    time.Time
+}
+
+// resultCache is an in-memory LRU cache of query responses bounded by total size.
+type resultCache struct {
+    mu       sync.Mutex
+    maxBytes int
+    size     int
+    order    *list.List // front is most recently used
+    entries  map[string]*list.Element
+}
+
+// CacheStats is the JSON view of the cache's occupancy.
+type CacheStats struct {
+    Entries  int `json:"entries"`
+    Bytes    int `json:"bytes"`
+    MaxBytes int `json:"max_bytes"`
+}
+
+var queryCache = newResultCache(cacheMaxBytes)
+
+func newResultCache(maxBytes int) *resultCache {
+    return &resultCache{
+        maxBytes: maxBytes,
+        order:    list.New(),
+        entries:  make(map[string]*list.Element),
+    }
+}
+
+// cacheKey identifies a query result by connection, normalized SQL and arguments.
+func cacheKey(connectionID, sqlQuery string, args []interface{}) string {
+    return connectionID + ":" + db.QueryFingerprint(sqlQuery, args, "")
+}
+
+// get returns a live entry and marks it most recently used.
+func (c *resultCache) get(key string) (*cacheEntry, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    elem, ok := c.entries[key]
+    if !ok {
+        return nil, false
+    }
+    entry := elem.Value.(*cacheEntry)
+    if time.Now().After(entry.expiresAt) {
+        c.remove(elem)
+        return nil, false
+    }
+    c.order.MoveToFront(elem)
+    return entry, true
+}
+
+// put stores an entry, evicting least recently used entries to stay within
+// the size limit. Entries larger than the per-entry limit are not cached.
+func (c *resultCache) put(entry *cacheEntry) {
+    if len(entry.body) > cacheMaxEntryBytes || len(entry.body) > c.maxBytes {
+        return
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if elem, ok := c.entries[entry.key]; ok {
+        c.remove(elem)
+    }
+    c.entries[entry.key] = c.order.PushFront(entry)
+    c.size += len(entry.body)
+
+    for c.size > c.maxBytes {
+        c.remove(c.order.Back())
+    }
+}
+
+// remove drops an element; the caller must hold mu.
+func (c *resultCache) remove(elem *list.Element) {
+    entry := elem.Value.(*cacheEntry)
+    c.order.Remove(elem)
+    delete(c.entries, entry.key)
+    c.size -= len(entry.body)
+}
+
+// invalidate drops every entry for a connection, or everything when
+// connectionID is empty, and returns how many were removed.
+func (c *resultCache) invalidate(connectionID string) int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    removed := 0
+    for elem := c.order.Front(); elem != nil; {
+        next := elem.Next()
+        if connectionID == "" || elem.Value.(*cacheEntry).connectionID == connectionID {
+            c.remove(elem)
+            removed++
+        }
+        elem = next
+    }
+    return removed
+}
+
+func (c *resultCache) stats() CacheStats {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return CacheStats{Entries: len(c.entries), Bytes: c.size, MaxBytes: c.maxBytes}
+}
+
+// cacheTTL clamps a requested TTL to the configured maximum.
+func cacheTTL(ttlMS int) time.Duration {
+    ttl := time.Duration(ttlMS) * time.Millisecond
+    if ttl > cacheMaxTTL {
+        ttl = cacheMaxTTL
+    }
+    return ttl
+}
+
+// setupCacheRoutes defines the routes for inspecting and invalidating the result cache.
+func setupCacheRoutes(router *mux.Router) {
+    // Route to show cache occupancy
+    router.HandleFunc("/database/cache", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(queryCache.stats())
+    }).Methods("GET")
+
+    // Route to invalidate cached results, for one connection or all of them
+    router.HandleFunc("/database/cache", func(w http.ResponseWriter, r *http.Request) {
+        removed := queryCache.invalidate(r.URL.Query().Get("connection_id"))
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]string{
+            "message": "Invalidated " + strconv.Itoa(removed) + " cached results",
+        })
+    }).Methods("DELETE")
+}
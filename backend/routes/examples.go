@@ -0,0 +1,176 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// textToSQLExamples is how many similar past examples go into each prompt.
+var textToSQLExamples = envInt("TEXT_TO_SQL_EXAMPLES", 3)
+
+// MarkExampleRequest is the body of POST /examples. Either history_id or
+// both question and sql_query must be given.
+type MarkExampleRequest struct {
+    ConnectionID string `json:"connection_id,omitempty"`
+    HistoryID    int    `json:"history_id,omitempty"`
+    Question     string `json:"question,omitempty"`
+    SQLQuery     string `json:"sql_query,omitempty"`
+}
+
+// similarExamples loads the user's examples most similar to question for a
+// connection. Failures are logged and yield no examples.
+func similarExamples(dbConn *gorm.DB, r *http.Request, uc *userConnection, question string) []db.PromptExample {
+    userID, ok := requestUserID(dbConn, r)
+    if !ok {
+        return nil
+    }
+    examples, err := db.SimilarExamples(dbConn, userID, uc.Key, question, textToSQLExamples)
+    if err != nil {
+        log.Println("Error loading SQL examples:", err)
+        return nil
+    }
+    return examples
+}
+
+// setupExampleRoutes defines the routes for managing few-shot examples.
+func setupExampleRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // Route to mark a question and SQL pair as good for a connection
+    router.HandleFunc("/examples", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        var req MarkExampleRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid input", http.StatusBadRequest)
+            return
+        }
+
+        example := db.SQLExample{
+            UserID:   userID,
+            Question: req.Question,
+            SQLQuery: req.SQLQuery,
+        }
+        connectionID := req.ConnectionID
+        var entry *db.QueryHistory
+        if req.HistoryID != 0 {
+            var err error
+            entry, err = db.GetQueryHistory(dbConn, userID, req.HistoryID)
+            if err != nil {
+                http.Error(w, "History entry not found", http.StatusNotFound)
+                return
+            }
+            if len(entry.Arguments) > 0 {
+                http.Error(w, "Parameterized queries cannot be used as examples", http.StatusBadRequest)
+                return
+            }
+            // A query that failed would teach the model to write broken SQL
+            if entry.Error != "" {
+                http.Error(w, "Failed queries cannot be used as examples", http.StatusBadRequest)
+                return
+            }
+            if example.Question == "" {
+                example.Question = entry.Question
+            }
+            if example.SQLQuery == "" {
+                example.SQLQuery = entry.ExecutedSQL
+            }
+            example.HistoryID = &entry.ID
+        }
+        if example.Question == "" || example.SQLQuery == "" {
+            http.Error(w, "Question and SQL query are required", http.StatusBadRequest)
+            return
+        }
+
+        // History entries remember their database, which may no longer be
+        // connected; older entries only have the per-process connection ID
+        if connectionID == "" && entry != nil && entry.ConnectionKey != "" {
+            example.ConnectionKey = entry.ConnectionKey
+            example.Driver = entry.Driver
+        } else {
+            if connectionID == "" && entry != nil {
+                connectionID = entry.ConnectionID
+            }
+            uc := lookupConnection(connectionID)
+            if uc == nil {
+                http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+                return
+            }
+            example.ConnectionKey = uc.Key
+            example.Driver = uc.Driver
+        }
+
+        if err := db.CreateSQLExample(dbConn, &example); err != nil {
+            log.Println("Error saving SQL example:", err)
+            http.Error(w, "Failed to save example", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(example)
+    }).Methods("POST")
+
+    // Route to list the current user's examples, optionally for one connection
+    router.HandleFunc("/examples", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        var connectionKey string
+        if connectionID := r.URL.Query().Get("connection_id"); connectionID != "" {
+            uc := lookupConnection(connectionID)
+            if uc == nil {
+                http.Error(w, "Connection not found", http.StatusNotFound)
+                return
+            }
+            connectionKey = uc.Key
+        }
+
+        examples, err := db.GetSQLExamples(dbConn, userID, connectionKey)
+        if err != nil {
+            http.Error(w, "Failed to retrieve examples", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(examples)
+    }).Methods("GET")
+
+    // Route to delete an example
+    router.HandleFunc("/examples/{id}", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        exampleID, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid example ID", http.StatusBadRequest)
+            return
+        }
+
+        if err := db.DeleteSQLExample(dbConn, userID, exampleID); err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                http.Error(w, "Example not found", http.StatusNotFound)
+                return
+            }
+            http.Error(w, "Failed to delete example", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusNoContent)
+    }).Methods("DELETE")
+}
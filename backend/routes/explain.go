@@ -0,0 +1,138 @@
+package routes
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// statusQueryTooExpensive is returned when a query's estimated cost exceeds
+// the configured threshold.
+const statusQueryTooExpensive = http.StatusUnprocessableEntity
+
+// ExplainRequest is the body of POST /database/explain.
+type ExplainRequest struct {
+    SQLQuery     string `json:"sql_query"`
+    ConnectionID string `json:"connection_id,omitempty"`
+    TimeoutMS    int    `json:"timeout_ms,omitempty"`
+}
+
+// ExplainResponse is a normalized plan plus how it compares to the cost threshold.
+type ExplainResponse struct {
+    *db.QueryPlan
+    MaxCost        float64 `json:"max_cost,omitempty"`
+    ExceedsMaxCost bool    `json:"exceeds_max_cost"`
+}
+
+// envFloat reads a positive number from the environment.
+func envFloat(key string, fallback float64) float64 {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback
+    }
+    f, err := strconv.ParseFloat(value, 64)
+    if err != nil || f <= 0 {
+        log.Printf("Ignoring invalid %s=%q", key, value)
+        return fallback
+    }
+    return f
+}
+
+// maxQueryCost returns the estimated cost above which queries are refused
+// for a driver, or 0 when there is no limit. Cost units differ between
+// databases, so QUERY_MAX_COST_<DRIVER> overrides the global QUERY_MAX_COST.
+func maxQueryCost(driver string) float64 {
+    return envFloat("QUERY_MAX_COST_"+strings.ToUpper(driver), envFloat("QUERY_MAX_COST", 0))
+}
+
+// checkQueryCost explains a query before it runs and writes a refusal when
+// its estimated cost is over the limit. With a limit set the check fails
+// closed: a query that can't be explained is refused, with 503 when EXPLAIN
+// timed out and 400 when the database rejected it, and so is one whose cost
+// the database doesn't estimate (SQLite), so set the limit per driver
+// through QUERY_MAX_COST_<DRIVER> when some connections are to SQLite.
+func checkQueryCost(w http.ResponseWriter, r *http.Request, uc *userConnection, timeoutMS int, sqlQuery string, args []interface{}) bool {
+    limit := maxQueryCost(uc.Driver)
+    if limit == 0 {
+        return true
+    }
+
+    ctx, cancel := queryContext(r, timeoutMS)
+    defer cancel()
+    plan, err := db.ExplainQuery(ctx, uc.DB, sqlQuery, args...)
+    if err != nil {
+        if ctx.Err() != nil {
+            if _, _, ok := queryErrorStatus(ctx, err); !ok {
+                log.Println("Client went away, cost check cancelled")
+                return false
+            }
+            http.Error(w, "Could not estimate the query's cost: "+err.Error(), http.StatusServiceUnavailable)
+            return false
+        }
+        http.Error(w, "Could not estimate the query's cost: "+err.Error(), http.StatusBadRequest)
+        return false
+    }
+    if !plan.CostKnown {
+        http.Error(w, fmt.Sprintf("A query cost limit is set but %s does not estimate query costs", uc.Driver), http.StatusServiceUnavailable)
+        return false
+    }
+    if plan.TotalCost <= limit {
+        return true
+    }
+
+    log.Printf("Refusing SQL query with estimated cost %.2f (limit %.2f)", plan.TotalCost, limit)
+    http.Error(w, fmt.Sprintf("Query estimated cost %.2f exceeds the limit of %.2f", plan.TotalCost, limit), statusQueryTooExpensive)
+    return false
+}
+
+// setupExplainRoutes defines the route for inspecting query plans.
+func setupExplainRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // Route to show a query's plan without running it
+    router.HandleFunc("/database/explain", func(w http.ResponseWriter, r *http.Request) {
+        var req ExplainRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid input", http.StatusBadRequest)
+            return
+        }
+        if req.SQLQuery == "" {
+            http.Error(w, "SQL query cannot be empty", http.StatusBadRequest)
+            return
+        }
+        if req.TimeoutMS < 0 {
+            http.Error(w, "timeout_ms cannot be negative", http.StatusBadRequest)
+            return
+        }
+
+        uc := lookupConnection(req.ConnectionID)
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return
+        }
+
+        ctx, cancel := queryContext(r, req.TimeoutMS)
+        defer cancel()
+        plan, err := db.ExplainQuery(ctx, uc.DB, req.SQLQuery)
+        if err != nil {
+            if ctx.Err() != nil {
+                writeQueryError(ctx, w, err)
+                return
+            }
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        response := ExplainResponse{QueryPlan: plan, MaxCost: maxQueryCost(uc.Driver)}
+        response.ExceedsMaxCost = response.MaxCost > 0 && plan.CostKnown && plan.TotalCost > response.MaxCost
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(response)
+    }).Methods("POST")
+}
@@ -0,0 +1,200 @@
+package routes
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// RerunRequest optionally overrides how a history entry is re-run.
+type RerunRequest struct {
+    ConnectionID string `json:"connection_id,omitempty"`
+    TimeoutMS    int    `json:"timeout_ms,omitempty"`
+    Stream       bool   `json:"stream,omitempty"`
+    Async        bool   `json:"async,omitempty"`
+}
+
+// requestSession resolves the session making the request from an
+// "Authorization: Bearer <session token>" header.
+func requestSession(dbConn *gorm.DB, r *http.Request) (*db.Session, bool) {
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || token == "" || dbConn == nil {
+        return nil, false
+    }
+
+    session, err := db.GetSessionByToken(dbConn, strings.TrimSpace(token))
+    if err != nil {
+        return nil, false
+    }
+    return session, true
+}
+
+// requestUserID resolves the user making the request from their session token.
+func requestUserID(dbConn *gorm.DB, r *http.Request) (int, bool) {
+    session, ok := requestSession(dbConn, r)
+    if !ok {
+        return 0, false
+    }
+    return session.UserID, true
+}
+
+// newHistoryEntry starts a history record for a query, or returns nil when
+// the request isn't tied to a user.
+func newHistoryEntry(r *http.Request, dbConn *gorm.DB, req QueryRequest, uc *userConnection) *db.QueryHistory {
+    userID, ok := requestUserID(dbConn, r)
+    if !ok {
+        return nil
+    }
+    return &db.QueryHistory{
+        UserID:        userID,
+        Question:      req.Question,
+        GeneratedSQL:  req.GeneratedSQL,
+        ExecutedSQL:   req.SQLQuery,
+        ConnectionID:  uc.ID,
+        ConnectionKey: uc.Key,
+        Driver:        uc.Driver,
+        Arguments:     req.args,
+    }
+}
+
+// recordHistory completes and saves a history entry. Failures are logged
+// rather than returned so they never affect the query response.
+func recordHistory(dbConn *gorm.DB, entry *db.QueryHistory, elapsed time.Duration, rowCount int64, err error) {
+    if entry == nil {
+        return
+    }
+    entry.DurationMS = elapsed.Milliseconds()
+    entry.RowCount = rowCount
+    if err != nil {
+        entry.Error = err.Error()
+    }
+    if err := db.CreateQueryHistory(dbConn, entry); err != nil {
+        log.Println("Error recording query history:", err)
+    }
+}
+
+// setupHistoryRoutes defines the routes for browsing and re-running a user's query history.
+func setupHistoryRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // Route to list the current user's history, with optional search and
+    // connection filters; connection_key also matches entries from earlier connections
+    router.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        params := r.URL.Query()
+        filter := db.HistoryFilter{
+            Search:        params.Get("q"),
+            ConnectionID:  params.Get("connection_id"),
+            ConnectionKey: params.Get("connection_key"),
+            Limit:         50,
+        }
+        if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit > 0 && limit <= 500 {
+            filter.Limit = limit
+        }
+        if offset, err := strconv.Atoi(params.Get("offset")); err == nil && offset > 0 {
+            filter.Offset = offset
+        }
+
+        entries, err := db.ListQueryHistory(dbConn, userID, filter)
+        if err != nil {
+            log.Println("Error fetching query history:", err)
+            http.Error(w, "Failed to retrieve query history", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(entries)
+    }).Methods("GET")
+
+    // Route to get a single history entry
+    router.HandleFunc("/history/{id}", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        historyID, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid history ID", http.StatusBadRequest)
+            return
+        }
+
+        entry, err := db.GetQueryHistory(dbConn, userID, historyID)
+        if err != nil {
+            http.Error(w, "History entry not found", http.StatusNotFound)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(entry)
+    }).Methods("GET")
+
+    // Route to re-run a history entry, on its original connection unless another is given
+    router.HandleFunc("/history/{id}/rerun", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        historyID, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid history ID", http.StatusBadRequest)
+            return
+        }
+
+        var rerun RerunRequest
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&rerun); err != nil {
+                http.Error(w, "Invalid input", http.StatusBadRequest)
+                return
+            }
+        }
+        if rerun.TimeoutMS < 0 {
+            http.Error(w, "timeout_ms cannot be negative", http.StatusBadRequest)
+            return
+        }
+
+        entry, err := db.GetQueryHistory(dbConn, userID, historyID)
+        if err != nil {
+            http.Error(w, "History entry not found", http.StatusNotFound)
+            return
+        }
+
+        // Prefer the original connection while it is still registered
+        connectionID := rerun.ConnectionID
+        if connectionID == "" && lookupConnection(entry.ConnectionID) != nil {
+            connectionID = entry.ConnectionID
+        }
+
+        // Bound placeholders are driver specific, so parameterized entries can
+        // only be re-run on the same kind of database
+        if uc := lookupConnection(connectionID); uc != nil && len(entry.Arguments) > 0 && uc.Driver != entry.Driver {
+            http.Error(w, fmt.Sprintf("This entry was bound for %s and cannot run on %s", entry.Driver, uc.Driver), http.StatusConflict)
+            return
+        }
+
+        serveQuery(w, r, dbConn, QueryRequest{
+            SQLQuery:     entry.ExecutedSQL,
+            TimeoutMS:    rerun.TimeoutMS,
+            Stream:       rerun.Stream,
+            Async:        rerun.Async,
+            ConnectionID: connectionID,
+            Question:     entry.Question,
+            GeneratedSQL: entry.GeneratedSQL,
+            args:         entry.Arguments,
+        })
+    }).Methods("POST")
+}
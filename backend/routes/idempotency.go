@@ -0,0 +1,96 @@
+package routes
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "net/http"
+    "time"
+
+    "backend/db"
+    "gorm.io/gorm"
+)
+
+// idempotencyKeyTTL is how long a key's response is replayed, from
+// IDEMPOTENCY_KEY_TTL_HOURS.
+var idempotencyKeyTTL = time.Duration(envInt("IDEMPOTENCY_KEY_TTL_HOURS", 24)) * time.Hour
+
+// idempotencyClaimLease is how long a request in progress holds its key
+// before a retry may claim it, from IDEMPOTENCY_CLAIM_LEASE_MS.
+var idempotencyClaimLease = envMilliseconds("IDEMPOTENCY_CLAIM_LEASE_MS", 5*time.Minute)
+
+// maxIdempotencyKeyLength matches the idempotency_keys column size.
+const maxIdempotencyKeyLength = 255
+
+// recordingWriter passes a response through while keeping a copy of it.
+type recordingWriter struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+    if rw.status == 0 {
+        rw.status = status
+    }
+    rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+    if rw.status == 0 {
+        rw.status = http.StatusOK
+    }
+    rw.body.Write(p)
+    return rw.ResponseWriter.Write(p)
+}
+
+// serveIdempotent runs handle at most once per Idempotency-Key header for an
+// endpoint. A retry with the same key and body gets the recorded response
+// back with Idempotent-Replayed set; reusing a key for a different body is
+// rejected. Server errors aren't recorded, so those requests can be retried.
+// Without the header, handle just runs.
+func serveIdempotent(w http.ResponseWriter, r *http.Request, dbConn *gorm.DB, endpoint string, body []byte, handle func(w http.ResponseWriter)) {
+    key := r.Header.Get("Idempotency-Key")
+    if key == "" {
+        handle(w)
+        return
+    }
+    if len(key) > maxIdempotencyKeyLength {
+        http.Error(w, "Idempotency-Key is longer than 255 characters", http.StatusBadRequest)
+        return
+    }
+
+    sum := sha256.Sum256(body)
+    record, claimed, err := db.ClaimIdempotencyKey(dbConn, endpoint, key, hex.EncodeToString(sum[:]), idempotencyKeyTTL, idempotencyClaimLease)
+    switch {
+    case errors.Is(err, db.ErrIdempotencyKeyReused):
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    case errors.Is(err, db.ErrIdempotencyKeyInProgress):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case err != nil:
+        http.Error(w, "Failed to check Idempotency-Key", http.StatusInternalServerError)
+        return
+    case !claimed:
+        if record.ContentType != "" {
+            w.Header().Set("Content-Type", record.ContentType)
+        }
+        w.Header().Set("Idempotent-Replayed", "true")
+        w.WriteHeader(record.StatusCode)
+        w.Write([]byte(record.Response))
+        return
+    }
+
+    rw := &recordingWriter{ResponseWriter: w}
+    handle(rw)
+    if rw.status == 0 || rw.status >= http.StatusInternalServerError {
+        db.ReleaseIdempotencyKey(dbConn, record)
+        return
+    }
+    if err := db.CompleteIdempotencyKey(dbConn, record, rw.status, w.Header().Get("Content-Type"), rw.body.String()); err != nil {
+        // Free the key rather than leave it in progress until it expires
+        db.ReleaseIdempotencyKey(dbConn, record)
+    }
+}
@@ -0,0 +1,526 @@
+package routes
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Job statuses
+const (
+    JobQueued    = "queued"
+    JobRunning   = "running"
+    JobSucceeded = "succeeded"
+    JobFailed    = "failed"
+    JobCancelled = "cancelled"
+)
+
+// jobIndexEvery is the row interval at which byte offsets into a job's
+// result file are remembered, so paging doesn't rescan the whole file.
+const jobIndexEvery = 1000
+
+// Job settings, overridable through the environment.
+var (
+    jobWorkers    = envInt("JOB_WORKERS", 4)
+    jobQueueSize  = envInt("JOB_QUEUE_SIZE", 100)
+    jobMaxTimeout = envMilliseconds("JOB_MAX_TIMEOUT_MS", 30*time.Minute)
+    jobRetention  = envMilliseconds("JOB_RETENTION_MS", 24*time.Hour)
+    jobResultsDir = envString("JOB_RESULTS_DIR", filepath.Join(os.TempDir(), "quarry-jobs"))
+)
+
+// queryJob is an asynchronous query whose results are spooled to an NDJSON file.
+type queryJob struct {
+    mu         sync.Mutex
+    ID         string
+    SQL        string
+    Status     string
+    QueryID    string
+    Columns    []string
+    RowCount   int64
+    Error      string
+    CreatedAt  time.Time
+    StartedAt  time.Time
+    FinishedAt time.Time
+
+    conn     *gorm.DB
+    args     []interface{}
+    timeout  time.Duration
+    onFinish func(JobInfo) // called once the job reaches a final status
+    ctx      context.Context
+    running  *runningQuery // Tracked from submission so a queued job can be cancelled too
+    path    string
+    index   []int64 // byte offset of row i*jobIndexEvery
+}
+
+// JobInfo is the JSON view of a job.
+type JobInfo struct {
+    ID         string     `json:"id"`
+    Status     string     `json:"status"`
+    SQLQuery   string     `json:"sql_query"`
+    QueryID    string     `json:"query_id,omitempty"`
+    Columns    []string   `json:"columns,omitempty"`
+    RowCount   int64      `json:"row_count"`
+    Error      string     `json:"error,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+    StartedAt  *time.Time `json:"started_at,omitempty"`
+    FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobResultsResponse is a page of a finished job's rows.
+type JobResultsResponse struct {
+    Columns    []string                 `json:"columns"`
+    Rows       []map[string]interface{} `json:"rows"`
+    Offset     int64                    `json:"offset"`
+    TotalRows  int64                    `json:"total_rows"`
+    NextOffset *int64                   `json:"next_offset,omitempty"`
+}
+
+var (
+    jobsMu   sync.Mutex
+    jobs     = make(map[string]*queryJob)
+    jobQueue chan *queryJob
+    jobsCtx  context.Context
+    stopJobs context.CancelFunc
+    jobsWG   sync.WaitGroup
+    jobsOnce sync.Once
+)
+
+// envInt reads a positive integer from the environment.
+func envInt(key string, fallback int) int {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback
+    }
+    n, err := strconv.Atoi(value)
+    if err != nil || n <= 0 {
+        log.Printf("Ignoring invalid %s=%q", key, value)
+        return fallback
+    }
+    return n
+}
+
+// envString reads a string from the environment.
+func envString(key, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return fallback
+}
+
+// startJobWorkers starts the bounded worker pool and the janitor that
+// removes expired jobs. It is safe to call more than once.
+func startJobWorkers() {
+    jobsOnce.Do(func() {
+        if err := os.MkdirAll(jobResultsDir, 0o700); err != nil {
+            log.Printf("Error creating job results directory %s: %v", jobResultsDir, err)
+        }
+        jobsCtx, stopJobs = context.WithCancel(context.Background())
+        jobQueue = make(chan *queryJob, jobQueueSize)
+
+        for i := 0; i < jobWorkers; i++ {
+            jobsWG.Add(1)
+            go func() {
+                defer jobsWG.Done()
+                for {
+                    select {
+                    case <-jobsCtx.Done():
+                        return
+                    case job := <-jobQueue:
+                        runJob(job)
+                    }
+                }
+            }()
+        }
+
+        jobsWG.Add(1)
+        go func() {
+            defer jobsWG.Done()
+            ticker := time.NewTicker(time.Minute)
+            defer ticker.Stop()
+            for {
+                select {
+                case <-jobsCtx.Done():
+                    return
+                case <-ticker.C:
+                    expireJobs()
+                }
+            }
+        }()
+    })
+}
+
+// StopJobs cancels running jobs, waits for the workers to exit and marks
+// the jobs still queued as cancelled.
+func StopJobs() {
+    if stopJobs == nil {
+        return
+    }
+    stopJobs()
+    jobsWG.Wait()
+
+    for {
+        select {
+        case job := <-jobQueue:
+            job.cancelIfQueued()
+        default:
+            return
+        }
+    }
+}
+
+// submitJob queues a query to run asynchronously. It fails if the queue is
+// full. The job's query is tracked from now on, so it can be cancelled
+// through DELETE /database/queries/{id} before it starts.
+func submitJob(conn *gorm.DB, sqlQuery string, args []interface{}, timeout time.Duration, onFinish func(JobInfo)) (*queryJob, error) {
+    startJobWorkers()
+
+    job := &queryJob{
+        ID:        newID(),
+        SQL:       sqlQuery,
+        Status:    JobQueued,
+        CreatedAt: time.Now(),
+        conn:      conn,
+        args:      args,
+        timeout:   timeout,
+        onFinish:  onFinish,
+    }
+    job.path = filepath.Join(jobResultsDir, job.ID+".ndjson")
+    job.ctx, job.running = trackQuery(jobsCtx, sqlQuery)
+    job.QueryID = job.running.ID
+
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    select {
+    case jobQueue <- job:
+        jobs[job.ID] = job
+    default:
+        job.running.done()
+        return nil, errors.New("job queue is full, try again later")
+    }
+
+    // A job cancelled by request while queued finishes right away rather
+    // than when a worker gets to it
+    context.AfterFunc(job.ctx, func() {
+        if errors.Is(context.Cause(job.ctx), errQueryCancelled) {
+            job.cancelIfQueued()
+        }
+    })
+    return job, nil
+}
+
+// cancelIfQueued marks a job that hasn't started as cancelled.
+func (job *queryJob) cancelIfQueued() {
+    job.mu.Lock()
+    if job.Status != JobQueued {
+        job.mu.Unlock()
+        return
+    }
+    job.Status = JobCancelled
+    job.Error = "query cancelled"
+    job.FinishedAt = time.Now()
+    job.mu.Unlock()
+
+    job.running.done()
+    job.finished()
+}
+
+// finished logs a job's final status and reports it to onFinish.
+func (job *queryJob) finished() {
+    info := job.info()
+    log.Printf("Job %s finished with status %s (%d rows)", info.ID, info.Status, info.RowCount)
+    if job.onFinish != nil {
+        job.onFinish(info)
+    }
+}
+
+// runJob executes a job and spools its rows to disk, unless it was
+// cancelled while queued.
+func runJob(job *queryJob) {
+    job.mu.Lock()
+    if job.Status != JobQueued {
+        job.mu.Unlock()
+        return
+    }
+    job.Status = JobRunning
+    job.StartedAt = time.Now()
+    job.mu.Unlock()
+
+    running := job.running
+    defer running.done()
+    ctx, cancel := context.WithTimeout(job.ctx, job.timeout)
+    defer cancel()
+
+    err := spoolJob(ctx, job, running)
+
+    job.mu.Lock()
+    job.FinishedAt = time.Now()
+    job.RowCount = running.rows.Load()
+    switch {
+    case err == nil:
+        job.Status = JobSucceeded
+    case job.ctx.Err() != nil:
+        // Cancelled by request or by StopJobs
+        job.Status = JobCancelled
+        job.Error = "query cancelled"
+    default:
+        job.Status = JobFailed
+        _, job.Error, _ = queryErrorStatus(ctx, err)
+        if job.Error == "" {
+            job.Error = err.Error()
+        }
+    }
+    job.mu.Unlock()
+
+    job.finished()
+}
+
+// spoolJob runs the job's query and writes one JSON object per row to its result file.
+func spoolJob(ctx context.Context, job *queryJob, running *runningQuery) error {
+    rows, err := db.ExecuteSQLQuery(ctx, job.conn, job.SQL, job.args...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    columns, err := rows.Columns()
+    if err != nil {
+        return err
+    }
+    job.mu.Lock()
+    job.Columns = columns
+    job.mu.Unlock()
+
+    file, err := os.Create(job.path)
+    if err != nil {
+        return fmt.Errorf("creating result file: %w", err)
+    }
+    defer file.Close()
+
+    counter := &countingWriter{w: bufio.NewWriter(file)}
+    enc := json.NewEncoder(counter)
+    var index []int64
+    err = db.EachRowMap(rows.Rows, func(row map[string]interface{}) error {
+        if running.rows.Load()%jobIndexEvery == 0 {
+            index = append(index, counter.n)
+        }
+        if err := enc.Encode(row); err != nil {
+            return err
+        }
+        running.rows.Add(1)
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    if err := counter.w.Flush(); err != nil {
+        return err
+    }
+
+    job.mu.Lock()
+    job.index = index
+    job.mu.Unlock()
+    return nil
+}
+
+// countingWriter tracks how many bytes have been written through it.
+type countingWriter struct {
+    w *bufio.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
+}
+
+// expireJobs removes finished jobs, and their result files, past the retention window.
+func expireJobs() {
+    cutoff := time.Now().Add(-jobRetention)
+
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    for id, job := range jobs {
+        job.mu.Lock()
+        expired := !job.FinishedAt.IsZero() && job.FinishedAt.Before(cutoff)
+        job.mu.Unlock()
+        if !expired {
+            continue
+        }
+        if err := os.Remove(job.path); err != nil && !os.IsNotExist(err) {
+            log.Printf("Error removing results for job %s: %v", id, err)
+        }
+        delete(jobs, id)
+    }
+}
+
+// info snapshots the job's state.
+func (job *queryJob) info() JobInfo {
+    job.mu.Lock()
+    defer job.mu.Unlock()
+
+    info := JobInfo{
+        ID:        job.ID,
+        Status:    job.Status,
+        SQLQuery:  job.SQL,
+        QueryID:   job.QueryID,
+        Columns:   job.Columns,
+        RowCount:  job.RowCount,
+        Error:     job.Error,
+        CreatedAt: job.CreatedAt,
+    }
+    if !job.StartedAt.IsZero() {
+        startedAt := job.StartedAt
+        info.StartedAt = &startedAt
+    }
+    if !job.FinishedAt.IsZero() {
+        finishedAt := job.FinishedAt
+        info.FinishedAt = &finishedAt
+    }
+    return info
+}
+
+// readJobRows reads up to limit rows starting at offset from a finished job's result file.
+func readJobRows(job *queryJob, offset, limit int64) ([]map[string]interface{}, error) {
+    job.mu.Lock()
+    index := job.index
+    job.mu.Unlock()
+
+    file, err := os.Open(job.path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    // Seek to the closest indexed row at or before offset
+    row := int64(0)
+    if slot := offset / jobIndexEvery; slot < int64(len(index)) {
+        if _, err := file.Seek(index[slot], io.SeekStart); err != nil {
+            return nil, err
+        }
+        row = slot * jobIndexEvery
+    }
+
+    rows := []map[string]interface{}{}
+    dec := json.NewDecoder(bufio.NewReader(file))
+    dec.UseNumber()
+    for int64(len(rows)) < limit {
+        var record map[string]interface{}
+        if err := dec.Decode(&record); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        if row >= offset {
+            rows = append(rows, record)
+        }
+        row++
+    }
+    return rows, nil
+}
+
+// lookupJob finds a job by the {id} route variable, writing a 404 if it is missing.
+func lookupJob(w http.ResponseWriter, r *http.Request) (*queryJob, bool) {
+    id := mux.Vars(r)["id"]
+
+    jobsMu.Lock()
+    job, ok := jobs[id]
+    jobsMu.Unlock()
+
+    if !ok {
+        http.Error(w, "Job not found", http.StatusNotFound)
+        return nil, false
+    }
+    return job, true
+}
+
+// setupJobRoutes defines the routes for polling and reading asynchronous query jobs.
+func setupJobRoutes(router *mux.Router) {
+    startJobWorkers()
+
+    // Route to get a job's status
+    router.HandleFunc("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+        job, ok := lookupJob(w, r)
+        if !ok {
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(job.info())
+    }).Methods("GET")
+
+    // Route to page through a finished job's results
+    router.HandleFunc("/jobs/{id}/results", func(w http.ResponseWriter, r *http.Request) {
+        job, ok := lookupJob(w, r)
+        if !ok {
+            return
+        }
+
+        info := job.info()
+        if info.Status != JobSucceeded {
+            http.Error(w, fmt.Sprintf("Job is %s, results are not available", info.Status), http.StatusConflict)
+            return
+        }
+
+        offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+        if err != nil || offset < 0 {
+            offset = 0
+        }
+        limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+        if err != nil || limit <= 0 || limit > 10000 {
+            limit = 1000
+        }
+
+        rows, err := readJobRows(job, offset, limit)
+        if err != nil {
+            log.Printf("Error reading results for job %s: %v", info.ID, err)
+            http.Error(w, "Failed to read job results", http.StatusInternalServerError)
+            return
+        }
+
+        response := JobResultsResponse{
+            Columns:   info.Columns,
+            Rows:      rows,
+            Offset:    offset,
+            TotalRows: info.RowCount,
+        }
+        if next := offset + int64(len(rows)); next < info.RowCount {
+            response.NextOffset = &next
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(response)
+    }).Methods("GET")
+
+    // Route to download a finished job's full result file
+    router.HandleFunc("/jobs/{id}/download", func(w http.ResponseWriter, r *http.Request) {
+        job, ok := lookupJob(w, r)
+        if !ok {
+            return
+        }
+
+        info := job.info()
+        if info.Status != JobSucceeded {
+            http.Error(w, fmt.Sprintf("Job is %s, results are not available", info.Status), http.StatusConflict)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.ID+".ndjson"))
+        http.ServeFile(w, r, job.path)
+    }).Methods("GET")
+}
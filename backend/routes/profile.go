@@ -0,0 +1,83 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Table profiling settings, overridable through the environment.
+var (
+    profileSampleRows    = envInt("PROFILE_SAMPLE_ROWS", 100000) // Larger tables are sampled
+    profileTopK          = envInt("PROFILE_TOP_K", 5)
+    profileLengthBuckets = envInt("PROFILE_LENGTH_BUCKETS", 10)
+)
+
+// maxProfileTopK bounds the top_k a request may ask for.
+const maxProfileTopK = 100
+
+// setupProfileRoutes defines the routes for profiling tables on user databases.
+func setupProfileRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // Route to report per-column statistics for a table
+    router.HandleFunc("/database/profile", func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        table := query.Get("table")
+        if table == "" {
+            http.Error(w, "Table name is required", http.StatusBadRequest)
+            return
+        }
+
+        opts := db.ProfileOptions{
+            SampleRows:    int64(profileSampleRows),
+            TopK:          profileTopK,
+            LengthBuckets: profileLengthBuckets,
+        }
+        if v := query.Get("top_k"); v != "" {
+            topK, err := strconv.Atoi(v)
+            if err != nil || topK < 0 || topK > maxProfileTopK {
+                http.Error(w, "top_k must be between 0 and 100", http.StatusBadRequest)
+                return
+            }
+            opts.TopK = topK
+        }
+        timeoutMS := 0
+        if v := query.Get("timeout_ms"); v != "" {
+            var err error
+            if timeoutMS, err = strconv.Atoi(v); err != nil || timeoutMS < 0 {
+                http.Error(w, "timeout_ms must be a non-negative integer", http.StatusBadRequest)
+                return
+            }
+        }
+
+        uc := lookupConnection(query.Get("connection_id"))
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return
+        }
+
+        ctx, cancel := queryContext(r, timeoutMS)
+        defer cancel()
+        profile, err := db.ProfileTable(ctx, uc.DB, table, opts)
+        if err != nil {
+            switch {
+            case errors.Is(err, db.ErrTableNotFound):
+                http.Error(w, err.Error(), http.StatusNotFound)
+            case ctx.Err() != nil:
+                writeQueryError(ctx, w, err)
+            default:
+                log.Printf("Error profiling table %s: %v", table, err)
+                http.Error(w, "Failed to profile table", http.StatusInternalServerError)
+            }
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(profile)
+    }).Methods("GET")
+}
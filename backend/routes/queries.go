@@ -0,0 +1,450 @@
+package routes
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// statusQueryCancelled is returned when a query is stopped through
+// DELETE /database/queries/{id} (nginx's "Client Closed Request").
+const statusQueryCancelled = 499
+
+// errQueryCancelled is the cancellation cause for queries stopped by a user.
+var errQueryCancelled = errors.New("query cancelled by request")
+
+// runningQuery is a query in flight on a user database.
+type runningQuery struct {
+    ID        string
+    SQL       string
+    StartedAt time.Time
+    rows      atomic.Int64
+    cancel    context.CancelCauseFunc
+}
+
+// RunningQueryInfo is the JSON view of a running query.
+type RunningQueryInfo struct {
+    ID           string    `json:"id"`
+    SQLQuery     string    `json:"sql_query"`
+    StartedAt    time.Time `json:"started_at"`
+    ElapsedMS    int64     `json:"elapsed_ms"`
+    RowsStreamed int64     `json:"rows_streamed"`
+    Cancelled    bool      `json:"cancelled,omitempty"`
+}
+
+var (
+    runningMu      sync.Mutex
+    runningQueries = make(map[string]*runningQuery)
+)
+
+// trackQuery registers a query so it can be listed and cancelled. The
+// returned context must be used to run it and done must be called when it finishes.
+func trackQuery(ctx context.Context, sqlQuery string) (context.Context, *runningQuery) {
+    ctx, cancel := context.WithCancelCause(ctx)
+    q := &runningQuery{
+        ID:        newID(),
+        SQL:       sqlQuery,
+        StartedAt: time.Now(),
+        cancel:    cancel,
+    }
+
+    runningMu.Lock()
+    runningQueries[q.ID] = q
+    runningMu.Unlock()
+    return ctx, q
+}
+
+// done unregisters the query and releases its context.
+func (q *runningQuery) done() {
+    runningMu.Lock()
+    delete(runningQueries, q.ID)
+    runningMu.Unlock()
+    q.cancel(nil)
+}
+
+// info snapshots the query's progress.
+func (q *runningQuery) info() RunningQueryInfo {
+    return RunningQueryInfo{
+        ID:           q.ID,
+        SQLQuery:     q.SQL,
+        StartedAt:    q.StartedAt,
+        ElapsedMS:    time.Since(q.StartedAt).Milliseconds(),
+        RowsStreamed: q.rows.Load(),
+    }
+}
+
+// setupQueryRoutes defines the routes for inspecting and cancelling running queries.
+func setupQueryRoutes(router *mux.Router) {
+    // Route to list queries currently running on user databases
+    router.HandleFunc("/database/queries/running", func(w http.ResponseWriter, r *http.Request) {
+        runningMu.Lock()
+        queries := make([]RunningQueryInfo, 0, len(runningQueries))
+        for _, q := range runningQueries {
+            queries = append(queries, q.info())
+        }
+        runningMu.Unlock()
+
+        sort.Slice(queries, func(i, j int) bool {
+            return queries[i].StartedAt.Before(queries[j].StartedAt)
+        })
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(queries)
+    }).Methods("GET")
+
+    // Route to cancel a running query
+    router.HandleFunc("/database/queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+        id := mux.Vars(r)["id"]
+
+        runningMu.Lock()
+        q, ok := runningQueries[id]
+        runningMu.Unlock()
+
+        if !ok {
+            http.Error(w, "Query not found or already finished", http.StatusNotFound)
+            return
+        }
+
+        q.cancel(errQueryCancelled)
+        log.Printf("Cancelled query %s on request", id)
+
+        info := q.info()
+        info.Cancelled = true
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(info)
+    }).Methods("DELETE")
+}
+
+// serveQuery runs a query request against the requested (or active)
+// connection and writes the response: a job handle when async, NDJSON frames
+// when streaming, or a single QueryResponse. Queries made with a session
+// token are recorded in that user's history.
+func serveQuery(w http.ResponseWriter, r *http.Request, dbConn *gorm.DB, req QueryRequest) {
+    // Ensure the database connection has been established
+    uc := lookupConnection(req.ConnectionID)
+    if uc == nil {
+        if req.ConnectionID != "" {
+            http.Error(w, "Connection not found", http.StatusNotFound)
+            return
+        }
+        log.Println("No database connection found")
+        http.Error(w, "No database connection found. Please connect to a database first.", http.StatusInternalServerError)
+        return
+    }
+
+    sqlQuery, args := req.SQLQuery, req.args
+    var page *db.Cursor
+    if req.PageSize != 0 || req.Cursor != "" {
+        if req.Async || req.Stream {
+            http.Error(w, "Pagination cannot be combined with async or stream", http.StatusBadRequest)
+            return
+        }
+        var err error
+        page, err = pageCursor(req.SQLQuery, req.args, req.PageSize, req.Cursor, req.OrderKey)
+        if err == nil {
+            sqlQuery, args, err = db.PaginateQuery(uc.Driver, sqlQuery, args, *page)
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+
+    entry := newHistoryEntry(r, dbConn, req, uc)
+
+    // Serve repeated queries from the result cache when the caller opts in,
+    // before the cost check, since a hit runs nothing. Async and streamed
+    // queries don't use the cache.
+    var key string
+    if req.CacheTTLMS > 0 && !req.Async && !req.Stream {
+        key = cacheKey(uc.ID, sqlQuery, args)
+        if cached, ok := queryCache.get(key); ok {
+            recordHistory(dbConn, entry, 0, cached.rowCount, nil)
+            w.Header().Set("Content-Type", "application/json")
+            w.Header().Set("X-Cache", "HIT")
+            w.WriteHeader(http.StatusOK)
+            w.Write(cached.body)
+            return
+        }
+        w.Header().Set("X-Cache", "MISS")
+    }
+
+    // Refuse queries the planner expects to be too expensive
+    if !checkQueryCost(w, r, uc, req.TimeoutMS, sqlQuery, args) {
+        return
+    }
+
+    if req.Async {
+        timeout := jobMaxTimeout
+        if requested := time.Duration(req.TimeoutMS) * time.Millisecond; requested > 0 && requested < timeout {
+            timeout = requested
+        }
+        job, err := submitJob(uc.DB, req.SQLQuery, req.args, timeout, func(info JobInfo) {
+            var jobErr error
+            if info.Error != "" {
+                jobErr = errors.New(info.Error)
+            }
+            var elapsed time.Duration
+            if info.StartedAt != nil && info.FinishedAt != nil {
+                elapsed = info.FinishedAt.Sub(*info.StartedAt)
+            }
+            recordHistory(dbConn, entry, elapsed, info.RowCount, jobErr)
+        })
+        if err != nil {
+            log.Println("Failed to queue SQL query job:", err)
+            http.Error(w, err.Error(), http.StatusServiceUnavailable)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Location", "/jobs/"+job.ID)
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(job.info())
+        return
+    }
+
+    if req.Stream {
+        ctx, cancel := queryContext(r, req.TimeoutMS)
+        defer cancel()
+        ctx, running := trackQuery(ctx, req.SQLQuery)
+        defer running.done()
+        w.Header().Set("X-Query-ID", running.ID)
+
+        err := streamQuery(ctx, w, uc.DB, running, req.args)
+        recordHistory(dbConn, entry, time.Since(running.StartedAt), running.rows.Load(), err)
+        return
+    }
+
+    ctx, cancel := queryContext(r, req.TimeoutMS)
+    defer cancel()
+    ctx, running := trackQuery(ctx, req.SQLQuery)
+    defer running.done()
+    w.Header().Set("X-Query-ID", running.ID)
+
+    // Execute the SQL query
+    columns, result, err := collectRows(ctx, db.ExecuteSQLQuery, uc.DB, running, sqlQuery, args)
+    rowCount := running.rows.Load()
+    if page != nil && rowCount > int64(page.PageSize) {
+        // Don't count the lookahead row of a page
+        rowCount = int64(page.PageSize)
+    }
+    recordHistory(dbConn, entry, time.Since(running.StartedAt), rowCount, err)
+    if err != nil {
+        writeQueryError(ctx, w, err)
+        return
+    }
+
+    // Send back the result
+    response := QueryResponse{
+        Result: result,
+    }
+    if page != nil {
+        // The page query fetches one extra row to tell whether more follow
+        hasMore := len(result) > page.PageSize
+        if hasMore {
+            result = result[:page.PageSize]
+        }
+        var lastRow map[string]interface{}
+        if len(result) > 0 {
+            lastRow = result[len(result)-1]
+        }
+        response.Result = result
+        response.NextCursor, err = db.NextCursor(*page, lastRow, hasMore)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    response.Chart = db.RecommendChart(columns, response.Result)
+
+    body, err := json.Marshal(response)
+    if err != nil {
+        log.Println("Error encoding query result:", err)
+        http.Error(w, "Failed to encode query result", http.StatusInternalServerError)
+        return
+    }
+    body = append(body, '\n')
+    if key != "" {
+        queryCache.put(&cacheEntry{
+            key:          key,
+            connectionID: uc.ID,
+            body:         body,
+            rowCount:     int64(len(result)),
+            expiresAt:    time.Now().Add(cacheTTL(req.CacheTTLMS)),
+        })
+    }
+
+    log.Println("SQL query executed successfully, returning result")
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    w.Write(body)
+}
+
+// queryExecutor runs a query; db.ExecuteSQLQuery or db.ExecuteReadOnlyQuery.
+type queryExecutor func(ctx context.Context, conn *gorm.DB, sqlQuery string, args ...interface{}) (*db.QueryRows, error)
+
+// collectRows executes a tracked query with execute and reads all of its
+// rows, returning the column names in result order alongside them.
+func collectRows(ctx context.Context, execute queryExecutor, conn *gorm.DB, running *runningQuery, sqlQuery string, args []interface{}) ([]string, []map[string]interface{}, error) {
+    rows, err := execute(ctx, conn, sqlQuery, args...)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
+
+    columns, err := rows.Columns()
+    if err != nil {
+        return nil, nil, err
+    }
+    result := []map[string]interface{}{}
+    err = db.EachRowMap(rows.Rows, func(row map[string]interface{}) error {
+        result = append(result, row)
+        running.rows.Add(1)
+        return nil
+    })
+    return columns, result, err
+}
+
+// defaultPageSize is used when a cursor-less paged request omits page_size.
+const defaultPageSize = 100
+
+// pageCursor resolves the page to fetch: the decoded cursor when one is
+// given, which must belong to this query, or the first page otherwise.
+func pageCursor(sqlQuery string, args []interface{}, pageSize int, token, orderKey string) (*db.Cursor, error) {
+    if pageSize < 0 || pageSize > db.MaxPageSize {
+        return nil, fmt.Errorf("page_size must be between 1 and %d", db.MaxPageSize)
+    }
+
+    if token == "" {
+        if pageSize == 0 {
+            pageSize = defaultPageSize
+        }
+        return &db.Cursor{
+            Fingerprint: db.QueryFingerprint(sqlQuery, args, orderKey),
+            PageSize:    pageSize,
+            OrderKey:    orderKey,
+        }, nil
+    }
+
+    cursor, err := db.DecodeCursor(token)
+    if err != nil {
+        return nil, err
+    }
+    if orderKey != "" && orderKey != cursor.OrderKey {
+        return nil, fmt.Errorf("%w: order_key differs from the cursor's", db.ErrInvalidCursor)
+    }
+    if cursor.Fingerprint != db.QueryFingerprint(sqlQuery, args, cursor.OrderKey) {
+        return nil, fmt.Errorf("%w: cursor belongs to a different query", db.ErrInvalidCursor)
+    }
+    if pageSize > 0 {
+        cursor.PageSize = pageSize
+    }
+    return cursor, nil
+}
+
+// queryErrorStatus maps a failed query to an HTTP status, based on why its
+// context ended. ok is false when the client went away and nothing should be written.
+func queryErrorStatus(ctx context.Context, err error) (status int, message string, ok bool) {
+    switch {
+    case errors.Is(context.Cause(ctx), errQueryCancelled):
+        return statusQueryCancelled, "query cancelled by request", true
+    case errors.Is(ctx.Err(), context.DeadlineExceeded):
+        return http.StatusGatewayTimeout, "query cancelled after exceeding its timeout: " + err.Error(), true
+    case errors.Is(ctx.Err(), context.Canceled):
+        return 0, "", false
+    }
+    return http.StatusInternalServerError, err.Error(), true
+}
+
+// writeQueryError writes a QueryResponse describing a failed query.
+func writeQueryError(ctx context.Context, w http.ResponseWriter, err error) {
+    status, message, ok := queryErrorStatus(ctx, err)
+    if !ok {
+        log.Println("Client went away, SQL query cancelled")
+        return
+    }
+    log.Println("Failed to execute SQL query:", err)
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(QueryResponse{Error: message})
+}
+
+// QueryStreamFrame is one line of a streamed (NDJSON) query response. The
+// first frame carries the query ID and columns, then one frame per row, then
+// a final frame with either done or error set.
+type QueryStreamFrame struct {
+    QueryID      string                 `json:"query_id,omitempty"`
+    Columns      []string               `json:"columns,omitempty"`
+    Row          map[string]interface{} `json:"row,omitempty"`
+    Done         bool                   `json:"done,omitempty"`
+    Error        string                 `json:"error,omitempty"`
+    RowsStreamed int64                  `json:"rows_streamed,omitempty"`
+    ElapsedMS    int64                  `json:"elapsed_ms,omitempty"`
+}
+
+// streamFlushEvery is how many row frames are written between flushes.
+const streamFlushEvery = 100
+
+// streamQuery runs the tracked query and writes its rows as NDJSON frames.
+// Once the first frame is sent the status is fixed at 200, so failures are
+// reported in the final frame. It returns the error the query failed with, if any.
+func streamQuery(ctx context.Context, w http.ResponseWriter, conn *gorm.DB, running *runningQuery, args []interface{}) error {
+    rows, err := db.ExecuteSQLQuery(ctx, conn, running.SQL, args...)
+    if err != nil {
+        writeQueryError(ctx, w, err)
+        return err
+    }
+    defer rows.Close()
+
+    columns, err := rows.Columns()
+    if err != nil {
+        writeQueryError(ctx, w, err)
+        return err
+    }
+
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    w.WriteHeader(http.StatusOK)
+    flusher := http.NewResponseController(w)
+    enc := json.NewEncoder(w)
+    enc.Encode(QueryStreamFrame{QueryID: running.ID, Columns: columns})
+    flusher.Flush()
+
+    err = db.EachRowMap(rows.Rows, func(row map[string]interface{}) error {
+        if err := enc.Encode(QueryStreamFrame{Row: row}); err != nil {
+            return err
+        }
+        if running.rows.Add(1)%streamFlushEvery == 0 {
+            flusher.Flush()
+        }
+        return nil
+    })
+
+    final := QueryStreamFrame{
+        RowsStreamed: running.rows.Load(),
+        ElapsedMS:    time.Since(running.StartedAt).Milliseconds(),
+    }
+    if err != nil {
+        _, message, ok := queryErrorStatus(ctx, err)
+        if !ok {
+            log.Println("Client went away, SQL query cancelled")
+            return err
+        }
+        log.Println("Failed to stream SQL query:", err)
+        final.Error = message
+    } else {
+        final.Done = true
+    }
+    enc.Encode(final)
+    flusher.Flush()
+    return err
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "backend/db"
+    "gorm.io/gorm"
+)
+
+// Soft-deleted users, transactions and sessions are purged for good once
+// they have been deleted for SOFT_DELETE_RETENTION_DAYS, checked every
+// PURGE_INTERVAL_MINUTES.
+var (
+    softDeleteRetention = time.Duration(envInt("SOFT_DELETE_RETENTION_DAYS", 30)) * 24 * time.Hour
+    purgeInterval       = time.Duration(envInt("PURGE_INTERVAL_MINUTES", 60)) * time.Minute
+)
+
+var (
+    stopPurge context.CancelFunc
+    purgeWG   sync.WaitGroup
+    purgeOnce sync.Once
+)
+
+// purgeDeleted removes records deleted longer ago than the retention window.
+func purgeDeleted(dbConn *gorm.DB) {
+    result, err := db.PurgeDeleted(dbConn, time.Now().Add(-softDeleteRetention))
+    if err != nil {
+        return
+    }
+    if result.Users > 0 || result.Transactions > 0 || result.Sessions > 0 {
+        log.Printf("Purged %d users, %d transactions and %d sessions deleted over %s ago",
+            result.Users, result.Transactions, result.Sessions, softDeleteRetention)
+    }
+}
+
+// StartPurgeJob purges expired soft-deleted records now and then every
+// purge interval until StopPurgeJob. It is safe to call more than once.
+func StartPurgeJob(dbConn *gorm.DB) {
+    purgeOnce.Do(func() {
+        var ctx context.Context
+        ctx, stopPurge = context.WithCancel(context.Background())
+
+        purgeWG.Add(1)
+        go func() {
+            defer purgeWG.Done()
+            purgeDeleted(dbConn)
+            ticker := time.NewTicker(purgeInterval)
+            defer ticker.Stop()
+            for {
+                select {
+                case <-ctx.Done():
+                    return
+                case <-ticker.C:
+                    purgeDeleted(dbConn)
+                }
+            }
+        }()
+    })
+}
+
+// StopPurgeJob stops the purge job and waits for a running purge to finish.
+func StopPurgeJob() {
+    if stopPurge == nil {
+        return
+    }
+    stopPurge()
+    purgeWG.Wait()
+}
@@ -0,0 +1,206 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// ExecuteSavedQueryRequest is the body of POST /saved-queries/{id}/execute.
+type ExecuteSavedQueryRequest struct {
+    ConnectionID string                     `json:"connection_id,omitempty"`
+    Params       map[string]json.RawMessage `json:"params"`
+    TimeoutMS    int                        `json:"timeout_ms,omitempty"`
+    Stream       bool                       `json:"stream,omitempty"`
+    Async        bool                       `json:"async,omitempty"`
+    CacheTTLMS   int                        `json:"cache_ttl_ms,omitempty"`
+}
+
+// setupSavedQueryRoutes defines the routes for managing and executing saved queries.
+func setupSavedQueryRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // savedQueryFromRequest loads the saved query named by the {id} route
+    // variable for the requesting user, writing an error response on failure.
+    savedQueryFromRequest := func(w http.ResponseWriter, r *http.Request) (int, *db.SavedQuery, bool) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return 0, nil, false
+        }
+
+        savedQueryID, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid saved query ID", http.StatusBadRequest)
+            return 0, nil, false
+        }
+
+        saved, err := db.GetSavedQuery(dbConn, userID, savedQueryID)
+        if err != nil {
+            http.Error(w, "Saved query not found", http.StatusNotFound)
+            return 0, nil, false
+        }
+        return userID, saved, true
+    }
+
+    // Route to create a new saved query
+    router.HandleFunc("/saved-queries", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        var saved db.SavedQuery
+        if err := json.NewDecoder(r.Body).Decode(&saved); err != nil {
+            http.Error(w, "Invalid input", http.StatusBadRequest)
+            return
+        }
+        if saved.Name == "" || saved.SQLQuery == "" {
+            http.Error(w, "Name and SQL query are required", http.StatusBadRequest)
+            return
+        }
+        saved.ID = 0
+        saved.UserID = userID
+
+        if err := db.CreateSavedQuery(dbConn, &saved); err != nil {
+            if errors.Is(err, db.ErrInvalidParameters) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            log.Println("Error creating saved query:", err)
+            http.Error(w, "Failed to create saved query", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(saved)
+    }).Methods("POST")
+
+    // Route to list the current user's saved queries
+    router.HandleFunc("/saved-queries", func(w http.ResponseWriter, r *http.Request) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        saved, err := db.GetSavedQueries(dbConn, userID)
+        if err != nil {
+            log.Println("Error fetching saved queries:", err)
+            http.Error(w, "Failed to retrieve saved queries", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(saved)
+    }).Methods("GET")
+
+    // Route to get a saved query
+    router.HandleFunc("/saved-queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+        _, saved, ok := savedQueryFromRequest(w, r)
+        if !ok {
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(saved)
+    }).Methods("GET")
+
+    // Route to update a saved query
+    router.HandleFunc("/saved-queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+        userID, saved, ok := savedQueryFromRequest(w, r)
+        if !ok {
+            return
+        }
+
+        savedQueryID := saved.ID
+        if err := json.NewDecoder(r.Body).Decode(saved); err != nil {
+            http.Error(w, "Invalid input", http.StatusBadRequest)
+            return
+        }
+        saved.ID = savedQueryID
+        saved.UserID = userID
+
+        if err := db.UpdateSavedQuery(dbConn, saved); err != nil {
+            if errors.Is(err, db.ErrInvalidParameters) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            log.Println("Error updating saved query:", err)
+            http.Error(w, "Failed to update saved query", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(saved)
+    }).Methods("PUT")
+
+    // Route to delete a saved query
+    router.HandleFunc("/saved-queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+        userID, saved, ok := savedQueryFromRequest(w, r)
+        if !ok {
+            return
+        }
+
+        if err := db.DeleteSavedQuery(dbConn, userID, saved.ID); err != nil {
+            log.Println("Error deleting saved query:", err)
+            http.Error(w, "Failed to delete saved query", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusNoContent)
+    }).Methods("DELETE")
+
+    // Route to execute a saved query with a parameter map
+    router.HandleFunc("/saved-queries/{id}/execute", func(w http.ResponseWriter, r *http.Request) {
+        _, saved, ok := savedQueryFromRequest(w, r)
+        if !ok {
+            return
+        }
+
+        var req ExecuteSavedQueryRequest
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                http.Error(w, "Invalid input", http.StatusBadRequest)
+                return
+            }
+        }
+        if req.TimeoutMS < 0 {
+            http.Error(w, "timeout_ms cannot be negative", http.StatusBadRequest)
+            return
+        }
+
+        uc := lookupConnection(req.ConnectionID)
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return
+        }
+        if saved.Driver != "" && saved.Driver != uc.Driver {
+            http.Error(w, fmt.Sprintf("Saved query targets %s, connection is %s", saved.Driver, uc.Driver), http.StatusConflict)
+            return
+        }
+
+        boundSQL, args, err := db.BindParameters(uc.Driver, saved.SQLQuery, saved.Parameters, req.Params)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        serveQuery(w, r, dbConn, QueryRequest{
+            SQLQuery:     boundSQL,
+            TimeoutMS:    req.TimeoutMS,
+            Stream:       req.Stream,
+            Async:        req.Async,
+            ConnectionID: uc.ID,
+            CacheTTLMS:   req.CacheTTLMS,
+            args:         args,
+        })
+    }).Methods("POST")
+}
@@ -0,0 +1,193 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gopkg.in/yaml.v3"
+    "gorm.io/gorm"
+)
+
+// semanticLayerAdmins are the emails of users allowed to change semantic
+// layers, from SEMANTIC_LAYER_ADMINS. A layer is shared by everyone asking
+// questions of its connection and feeds their prompts, so when unset nobody
+// may change one.
+var semanticLayerAdmins = strings.FieldsFunc(envString("SEMANTIC_LAYER_ADMINS", ""), func(r rune) bool {
+    return r == ',' || r == ' '
+})
+
+// maxSemanticLayerBytes bounds the size of an uploaded definition.
+const maxSemanticLayerBytes = 1 << 20
+
+// ResolveRequest is the body of POST /semantic-layer/resolve.
+type ResolveRequest struct {
+    ConnectionID string `json:"connection_id,omitempty"`
+    Question     string `json:"question"`
+}
+
+// canEditSemanticLayer reports whether a user may change semantic layers.
+func canEditSemanticLayer(dbConn *gorm.DB, userID int) bool {
+    if len(semanticLayerAdmins) == 0 {
+        return false
+    }
+    user, err := db.GetUser(dbConn, userID)
+    if err != nil {
+        return false
+    }
+    for _, email := range semanticLayerAdmins {
+        if strings.EqualFold(email, user.Email) {
+            return true
+        }
+    }
+    return false
+}
+
+// resolveSemantics matches a question against the connection's semantic
+// layer. It returns nil when there is no layer or nothing matched.
+func resolveSemantics(dbConn *gorm.DB, uc *userConnection, question string) *db.SemanticResolution {
+    layer, err := db.GetSemanticLayer(dbConn, uc.Key)
+    if err != nil {
+        if !errors.Is(err, gorm.ErrRecordNotFound) {
+            log.Println("Error loading semantic layer:", err)
+        }
+        return nil
+    }
+    res := layer.Definition.Resolve(question)
+    if res.Empty() {
+        return nil
+    }
+    return res
+}
+
+// setupSemanticLayerRoutes defines the routes for managing per-connection semantic layers.
+func setupSemanticLayerRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // connectionForLayer authenticates the request and finds the connection
+    // named by the connection_id query parameter, or the active one.
+    connectionForLayer := func(w http.ResponseWriter, r *http.Request) (int, *userConnection, bool) {
+        userID, ok := requestUserID(dbConn, r)
+        if !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return 0, nil, false
+        }
+        uc := lookupConnection(r.URL.Query().Get("connection_id"))
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return 0, nil, false
+        }
+        return userID, uc, true
+    }
+
+    // Route to get a connection's semantic layer, as YAML when the client asks for it
+    router.HandleFunc("/semantic-layer", func(w http.ResponseWriter, r *http.Request) {
+        _, uc, ok := connectionForLayer(w, r)
+        if !ok {
+            return
+        }
+
+        layer, err := db.GetSemanticLayer(dbConn, uc.Key)
+        if err != nil {
+            http.Error(w, "No semantic layer is defined for this connection", http.StatusNotFound)
+            return
+        }
+
+        if strings.Contains(r.Header.Get("Accept"), "yaml") {
+            data, err := yaml.Marshal(layer.Definition)
+            if err != nil {
+                http.Error(w, "Failed to encode semantic layer", http.StatusInternalServerError)
+                return
+            }
+            w.Header().Set("Content-Type", "application/yaml")
+            w.Write(data)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(layer)
+    }).Methods("GET")
+
+    // Route to define or replace a connection's semantic layer from YAML or JSON
+    router.HandleFunc("/semantic-layer", func(w http.ResponseWriter, r *http.Request) {
+        userID, uc, ok := connectionForLayer(w, r)
+        if !ok {
+            return
+        }
+        if !canEditSemanticLayer(dbConn, userID) {
+            http.Error(w, "Only semantic layer admins can change definitions", http.StatusForbidden)
+            return
+        }
+
+        body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSemanticLayerBytes))
+        if err != nil {
+            http.Error(w, "Definition is too large", http.StatusRequestEntityTooLarge)
+            return
+        }
+        def, err := db.ParseSemanticDefinition(body)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        layer, err := db.SaveSemanticLayer(dbConn, uc.Key, *def, userID)
+        if err != nil {
+            http.Error(w, "Failed to save semantic layer", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(layer)
+    }).Methods("PUT")
+
+    // Route to remove a connection's semantic layer
+    router.HandleFunc("/semantic-layer", func(w http.ResponseWriter, r *http.Request) {
+        userID, uc, ok := connectionForLayer(w, r)
+        if !ok {
+            return
+        }
+        if !canEditSemanticLayer(dbConn, userID) {
+            http.Error(w, "Only semantic layer admins can change definitions", http.StatusForbidden)
+            return
+        }
+
+        if err := db.DeleteSemanticLayer(dbConn, uc.Key); err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                http.Error(w, "No semantic layer is defined for this connection", http.StatusNotFound)
+                return
+            }
+            http.Error(w, "Failed to delete semantic layer", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusNoContent)
+    }).Methods("DELETE")
+
+    // Route to show which definitions a question resolves to
+    router.HandleFunc("/semantic-layer/resolve", func(w http.ResponseWriter, r *http.Request) {
+        if _, ok := requestUserID(dbConn, r); !ok {
+            http.Error(w, "A valid session token is required", http.StatusUnauthorized)
+            return
+        }
+
+        var req ResolveRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Question == "" {
+            http.Error(w, "A question is required", http.StatusBadRequest)
+            return
+        }
+        uc := lookupConnection(req.ConnectionID)
+        if uc == nil {
+            http.Error(w, "No database connection found. Please connect to a database first.", http.StatusNotFound)
+            return
+        }
+
+        res := resolveSemantics(dbConn, uc, req.Question)
+        if res == nil {
+            res = &db.SemanticResolution{}
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(res)
+    }).Methods("POST")
+}
@@ -0,0 +1,316 @@
+package routes
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "backend/db"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Transaction request size limits.
+const (
+    maxTransactionBytes = 1 << 20  // A single transaction
+    maxBulkImportBytes  = 32 << 20 // A bulk import body
+)
+
+// bulkImportMaxRows caps how many transactions one bulk import may hold.
+var bulkImportMaxRows = envInt("BULK_IMPORT_MAX_ROWS", 10000)
+
+// bulkImportReadTimeout bounds the upload of a bulk import body, which may
+// take longer than the server's ReadTimeout allows ordinary requests.
+// Override with BULK_IMPORT_READ_TIMEOUT_MS.
+var bulkImportReadTimeout = envMilliseconds("BULK_IMPORT_READ_TIMEOUT_MS", 5*time.Minute)
+
+// Date ranges of analytics requests, overridable through the environment.
+var (
+    analyticsDefaultDays = envInt("ANALYTICS_DEFAULT_DAYS", 90) // Range covered when from is left out
+    analyticsMaxDays     = envInt("ANALYTICS_MAX_DAYS", 731)
+)
+
+// BulkImportResponse reports the outcome of a bulk import. Imports are all
+// or nothing: either every row is imported or Errors lists the bad rows.
+type BulkImportResponse struct {
+    Imported int              `json:"imported"`
+    Errors   []db.ImportError `json:"errors,omitempty"`
+}
+
+// TransactionListResponse is a page of a transaction listing.
+type TransactionListResponse struct {
+    Transactions []db.Transaction `json:"transactions"`
+    NextCursor   string           `json:"next_cursor,omitempty"` // Set when more transactions follow
+}
+
+// parseDateParam reads a date range bound given as RFC 3339 or YYYY-MM-DD.
+// Plain dates are midnight in loc.
+func parseDateParam(query url.Values, key string, loc *time.Location) (*time.Time, error) {
+    v := query.Get(key)
+    if v == "" {
+        return nil, nil
+    }
+    for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+        if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+            return &t, nil
+        }
+    }
+    return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 time", key)
+}
+
+// parseAmountParam reads an amount range bound in minor units.
+func parseAmountParam(query url.Values, key string) (*int64, error) {
+    v := query.Get(key)
+    if v == "" {
+        return nil, nil
+    }
+    amount, err := strconv.ParseInt(v, 10, 64)
+    if err != nil {
+        return nil, fmt.Errorf("%s must be an integer amount in minor units", key)
+    }
+    return &amount, nil
+}
+
+// parseTransactionFilter reads listing filters from query parameters, with
+// plain dates in loc.
+func parseTransactionFilter(query url.Values, loc *time.Location) (db.TransactionFilter, error) {
+    var filter db.TransactionFilter
+    var err error
+    if v := query.Get("user_id"); v != "" {
+        if filter.UserID, err = strconv.Atoi(v); err != nil {
+            return filter, errors.New("user_id must be an integer")
+        }
+    }
+    if filter.From, err = parseDateParam(query, "from", loc); err != nil {
+        return filter, err
+    }
+    if filter.To, err = parseDateParam(query, "to", loc); err != nil {
+        return filter, err
+    }
+    if filter.MinAmount, err = parseAmountParam(query, "min_amount"); err != nil {
+        return filter, err
+    }
+    if filter.MaxAmount, err = parseAmountParam(query, "max_amount"); err != nil {
+        return filter, err
+    }
+    filter.Category = query.Get("category")
+    filter.Status = query.Get("status")
+    filter.Currency = query.Get("currency")
+    return filter, nil
+}
+
+// parseTransactionPage reads sort, order, limit and cursor query parameters.
+func parseTransactionPage(query url.Values) (db.TransactionPage, error) {
+    page := db.TransactionPage{Sort: query.Get("sort"), Cursor: query.Get("cursor")}
+    switch query.Get("order") {
+    case "", "asc":
+    case "desc":
+        page.Desc = true
+    default:
+        return page, errors.New("order must be asc or desc")
+    }
+    if v := query.Get("limit"); v != "" {
+        limit, err := strconv.Atoi(v)
+        if err != nil || limit <= 0 || limit > db.MaxTransactionPageSize {
+            return page, fmt.Errorf("limit must be between 1 and %d", db.MaxTransactionPageSize)
+        }
+        page.Limit = limit
+    }
+    return page, nil
+}
+
+// serveTransactionList writes a page of the transactions matching filter.
+func serveTransactionList(w http.ResponseWriter, r *http.Request, dbConn *gorm.DB, filter db.TransactionFilter) {
+    page, err := parseTransactionPage(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    transactions, next, err := db.ListTransactions(dbConn, filter, page)
+    if err != nil {
+        if errors.Is(err, db.ErrInvalidListing) || errors.Is(err, db.ErrInvalidCursor) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        http.Error(w, "Failed to retrieve transactions", http.StatusInternalServerError)
+        return
+    }
+    if transactions == nil {
+        transactions = []db.Transaction{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(TransactionListResponse{Transactions: transactions, NextCursor: next})
+}
+
+// setupTransactionListRoutes defines the routes for listing and aggregating transactions.
+func setupTransactionListRoutes(router *mux.Router, dbConn *gorm.DB) {
+    // Route to list transactions, filtered, sorted and paged
+    router.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
+        filter, err := parseTransactionFilter(r.URL.Query(), time.UTC)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        serveTransactionList(w, r, dbConn, filter)
+    }).Methods("GET")
+
+    // Route to list one user's transactions, with the same filters
+    router.HandleFunc("/users/{id}/transactions", func(w http.ResponseWriter, r *http.Request) {
+        userID, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid user ID", http.StatusBadRequest)
+            return
+        }
+        // Count rather than GetUser, which preloads every transaction
+        var users int64
+        if err := dbConn.Model(&db.User{}).Where("id = ?", userID).Count(&users).Error; err != nil {
+            http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+            return
+        }
+        if users == 0 {
+            http.Error(w, "User not found", http.StatusNotFound)
+            return
+        }
+
+        filter, err := parseTransactionFilter(r.URL.Query(), time.UTC)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        filter.UserID = userID
+        serveTransactionList(w, r, dbConn, filter)
+    }).Methods("GET")
+
+    // Route to count, sum and average transactions per day, week or month,
+    // optionally per user, country or state, in a given time zone
+    router.HandleFunc("/transactions/analytics", func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        loc := time.UTC
+        if tz := query.Get("tz"); tz != "" {
+            var err error
+            if loc, err = time.LoadLocation(tz); err != nil {
+                http.Error(w, "tz must be an IANA time zone such as Europe/Paris", http.StatusBadRequest)
+                return
+            }
+        }
+        filter, err := parseTransactionFilter(query, loc)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        // Bound the range so a dashboard never aggregates the whole table
+        if filter.To == nil {
+            now := time.Now().In(loc)
+            filter.To = &now
+        }
+        if filter.From == nil {
+            to := filter.To.In(loc)
+            from := time.Date(to.Year(), to.Month(), to.Day()-analyticsDefaultDays, 0, 0, 0, 0, loc)
+            filter.From = &from
+        }
+        if !filter.From.Before(*filter.To) {
+            http.Error(w, "from must be before to", http.StatusBadRequest)
+            return
+        }
+        if filter.To.Sub(*filter.From) > time.Duration(analyticsMaxDays)*24*time.Hour {
+            http.Error(w, fmt.Sprintf("The date range may span at most %d days", analyticsMaxDays), http.StatusBadRequest)
+            return
+        }
+
+        ctx, cancel := queryContext(r, 0)
+        defer cancel()
+        analytics, err := db.AggregateTransactions(ctx, dbConn, filter, query.Get("interval"), query.Get("group_by"), loc)
+        if err != nil {
+            if errors.Is(err, db.ErrInvalidAggregation) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            http.Error(w, "Failed to aggregate transactions", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(analytics)
+    }).Methods("GET")
+
+    // Route to import many transactions at once from CSV or a JSON array
+    router.HandleFunc("/transactions/bulk", func(w http.ResponseWriter, r *http.Request) {
+        rc := http.NewResponseController(w)
+        if err := rc.SetReadDeadline(time.Now().Add(bulkImportReadTimeout)); err != nil {
+            log.Println("Error extending bulk import read deadline:", err)
+        }
+        body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBulkImportBytes))
+        if err != nil {
+            var tooLarge *http.MaxBytesError
+            if errors.As(err, &tooLarge) {
+                http.Error(w, "Import is too large", http.StatusRequestEntityTooLarge)
+                return
+            }
+            http.Error(w, "Failed to read import", http.StatusBadRequest)
+            return
+        }
+        // The write deadline was set when the request arrived; a slow upload
+        // shouldn't use up the time left for importing and responding
+        if err := rc.SetWriteDeadline(time.Now().Add(WriteTimeout())); err != nil {
+            log.Println("Error extending bulk import write deadline:", err)
+        }
+
+        serveIdempotent(w, r, dbConn, "POST /transactions/bulk", body, func(w http.ResponseWriter) {
+            writeResponse := func(status int, response BulkImportResponse) {
+                w.Header().Set("Content-Type", "application/json")
+                w.WriteHeader(status)
+                json.NewEncoder(w).Encode(response)
+            }
+
+            // CSV amounts are decimals in major units; JSON amounts are minor units as elsewhere
+            var transactions []db.Transaction
+            mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+            if mediaType == "text/csv" {
+                var problems []db.ImportError
+                transactions, problems, err = db.ParseTransactionCSV(bytes.NewReader(body))
+                if err != nil {
+                    http.Error(w, err.Error(), http.StatusBadRequest)
+                    return
+                }
+                if len(problems) > 0 {
+                    writeResponse(http.StatusUnprocessableEntity, BulkImportResponse{Errors: problems})
+                    return
+                }
+            } else if err := json.Unmarshal(body, &transactions); err != nil {
+                http.Error(w, "Expected a JSON array of transactions or text/csv", http.StatusBadRequest)
+                return
+            }
+
+            if len(transactions) == 0 {
+                http.Error(w, "No transactions to import", http.StatusBadRequest)
+                return
+            }
+            if len(transactions) > bulkImportMaxRows {
+                http.Error(w, fmt.Sprintf("An import may hold at most %d transactions", bulkImportMaxRows), http.StatusRequestEntityTooLarge)
+                return
+            }
+
+            problems, err := db.ImportTransactions(dbConn, transactions)
+            if err != nil {
+                if errors.Is(err, db.ErrImportRejected) {
+                    writeResponse(http.StatusUnprocessableEntity, BulkImportResponse{Errors: problems})
+                    return
+                }
+                http.Error(w, "Failed to import transactions", http.StatusInternalServerError)
+                return
+            }
+            writeResponse(http.StatusCreated, BulkImportResponse{Imported: len(transactions)})
+        })
+    }).Methods("POST")
+}